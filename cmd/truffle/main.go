@@ -1,32 +1,193 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/m4tthewde/truffle/internal/config"
 	"github.com/m4tthewde/truffle/internal/handlers"
+	"github.com/m4tthewde/truffle/internal/logging"
+	"github.com/m4tthewde/truffle/internal/proxy"
+	"github.com/m4tthewde/truffle/internal/redact"
+	"github.com/m4tthewde/truffle/internal/room"
 	"github.com/m4tthewde/truffle/internal/session"
+	"github.com/m4tthewde/truffle/internal/static"
 )
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	err := config.LoadConfig()
+	// until the configured logger is set up
+	log.SetOutput(redact.NewWriter(os.Stderr))
+
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "config" {
+		configCommand(args[1:])
+		return
+	}
+
+	err := config.LoadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	err = logging.Init(redact.NewWriter(os.Stderr), config.Conf.Log.Level, config.Conf.Log.Format)
+	if err != nil {
+		fatal("Setting up logging failed", err)
+	}
+
+	err = proxy.Init(config.Conf.TrustedProxies)
+	if err != nil {
+		fatal("Invalid trusted proxies", err)
+	}
+
+	err = static.Init()
+	if err != nil {
+		fatal("Loading static assets failed", err)
+	}
+
+	redact.Add(config.Conf.ClientSecret)
+	redact.Add(config.Conf.CookieSecret)
+	for _, key := range config.Conf.TokenKeys {
+		redact.Add(key)
+	}
+
+	err = session.Init()
 	if err != nil {
-		log.Fatalln(err)
+		fatal("Opening the session store failed", err)
 	}
 
-	session.Init()
 	go session.CleanupTicker()
+	go session.ValidateTicker()
+
+	server := &http.Server{
+		Addr:    config.Conf.Listen,
+		Handler: handlers.LogRequests(handlers.SecurityHeaders(handlers.Router())),
+		// errors of the server itself, like failed TLS handshakes
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		slog.Info("Starting server", "listen", config.Conf.Listen, "url", config.Conf.URL)
 
-	http.HandleFunc("/", handlers.RootHandler)
-	http.HandleFunc("/chat", handlers.ChatHandler)
-	http.HandleFunc("/chatroom", handlers.ChatRoomHandler)
-	http.HandleFunc("/chat/messages", handlers.WsChatHandler)
-	http.HandleFunc("/settings", handlers.SettingsHandler)
-	http.HandleFunc("/login", handlers.LoginHandler)
-	http.HandleFunc("/logout", handlers.LogoutHandler)
+		var err error
+		if config.Conf.TLS.Enabled() {
+			err = server.ListenAndServeTLS(config.Conf.TLS.CertFile, config.Conf.TLS.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Server failed", err)
+		}
+	}()
+
+	var metricsServer *http.Server
+	if config.Conf.MetricsListen != "" {
+		metricsServer = &http.Server{
+			Addr:     config.Conf.MetricsListen,
+			Handler:  handlers.MetricsRouter(),
+			ErrorLog: server.ErrorLog,
+		}
+
+		go func() {
+			slog.Info("Starting metrics server", "listen", config.Conf.MetricsListen)
+
+			err := metricsServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("Metrics server failed", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	stop()
+	shutdown(server, metricsServer)
+}
+
+// shutdown fails the readiness check for the shutdown delay, then stops
+// accepting requests, tells open chat rooms that the server is restarting
+// and waits for them to clean up, all within the shutdown timeout. The
+// metrics server is optional.
+func shutdown(server *http.Server, metricsServer *http.Server) {
+	slog.Info("Shutting down")
+	handlers.SetShuttingDown()
+
+	// keep serving while load balancers take the instance out of rotation
+	time.Sleep(time.Duration(config.Conf.ShutdownDelay))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Conf.ShutdownTimeout))
+	defer cancel()
+
+	// websockets are hijacked connections, Shutdown doesn't wait for them
+	err := server.Shutdown(ctx)
+	if err != nil {
+		slog.Error("Stopping the server failed", "err", err)
+	}
+
+	if metricsServer != nil {
+		err = metricsServer.Shutdown(ctx)
+		if err != nil {
+			slog.Error("Stopping the metrics server failed", "err", err)
+		}
+	}
+
+	room.CloseAll(room.ErrShutdown)
+	err = room.Wait(ctx)
+	if err != nil {
+		slog.Warn("Not all chat rooms closed in time", "err", err)
+	}
+
+	err = session.Close()
+	if err != nil {
+		slog.Error("Closing the session store failed", "err", err)
+	}
+}
+
+// configCommand implements "truffle config print [flags]", which shows the
+// effective configuration with secrets masked.
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: truffle config print [flags]")
+		os.Exit(2)
+	}
+
+	conf, err := config.Load(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		fatal("Loading the configuration failed", err)
+	}
+
+	err = conf.Print(os.Stdout)
+	if err != nil {
+		fatal("Printing the configuration failed", err)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
+}
 
-	log.Println("Starting server on port 8080")
-	http.ListenAndServe(":8080", nil)
+func fatal(msg string, err error) {
+	logging.ErrorSkip(context.Background(), 1, msg, err)
+	os.Exit(1)
 }
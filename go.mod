@@ -1,13 +1,23 @@
 module github.com/m4tthewde/truffle
 
-go 1.21.6
+go 1.22
 
 require (
+	github.com/a-h/templ v0.2.543
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.1
+	github.com/prometheus/client_golang v1.19.0
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/time v0.5.0
 )
 
 require (
-	github.com/a-h/templ v0.2.543 // indirect
-	golang.org/x/net v0.17.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	google.golang.org/protobuf v1.32.0 // indirect
 )
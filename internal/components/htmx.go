@@ -0,0 +1,15 @@
+package components
+
+import "encoding/json"
+
+// htmxConfig turns off the htmx features that would undermine the
+// Content-Security-Policy: evaluating code from attributes, running scripts
+// in swapped content and injecting its own styles.
+const htmxConfig = `{"allowEval":false,"allowScriptTags":false,"includeIndicatorStyles":false}`
+
+// csrfHeaders makes htmx send the CSRF token with every request, see
+// handlers.CSRFHeader.
+func csrfHeaders(token string) string {
+	headers, _ := json.Marshal(map[string]string{"X-CSRF-Token": token})
+	return string(headers)
+}
@@ -1,9 +1,20 @@
 package config
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/m4tthewde/truffle/internal/proxy"
 )
 
 var (
@@ -14,10 +25,202 @@ type Config struct {
 	ClientID     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
 	URL          string `json:"url"`
+	// Listen is the address the server listens on, like ":8080"
+	Listen string `json:"listen"`
+	// MetricsListen is a separate address for /metrics, like
+	// "127.0.0.1:9090". Metrics aren't served without it, they shouldn't be
+	// public.
+	MetricsListen string    `json:"metrics_listen"`
+	TLS           TLSConfig `json:"tls"`
+	// TrustedProxies are IPs or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For and X-Forwarded-Proto headers are honored
+	TrustedProxies []string `json:"trusted_proxies"`
+	// ShutdownDelay is how long /readyz fails before the server stops
+	// accepting requests, so load balancers notice in time
+	ShutdownDelay Duration `json:"shutdown_delay"`
+	// ShutdownTimeout bounds how long open chat rooms are drained on shutdown
+	ShutdownTimeout Duration           `json:"shutdown_timeout"`
+	CookieSecret    string             `json:"cookie_secret"`
+	Session         SessionConfig      `json:"session"`
+	SessionStore    SessionStoreConfig `json:"session_store"`
+	// TokenKeys are base64 encoded 32 byte keys used to encrypt stored
+	// tokens. The first one encrypts, the others are only used to decrypt
+	// tokens from before a key rotation.
+	TokenKeys []string        `json:"token_keys"`
+	Log       LogConfig       `json:"log"`
+	Overlay   OverlayConfig   `json:"overlay"`
+	RateLimit RateLimitConfig `json:"rate_limit"`
+}
+
+// TLSConfig enables TLS if both files are set.
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+type SessionConfig struct {
+	// Lifetime is how long a session lasts after login, no matter how active
+	Lifetime Duration `json:"lifetime"`
+	// IdleTimeout ends sessions that haven't been used for this long
+	IdleTimeout Duration `json:"idle_timeout"`
+}
+
+type SessionStoreConfig struct {
+	// Backend is either "memory" or "bolt"
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+}
+
+type LogConfig struct {
+	// Level is one of "debug", "info", "warn" or "error"
+	Level string `json:"level"`
+	// Format is either "text" or "json"
+	Format string `json:"format"`
+}
+
+type OverlayConfig struct {
+	// FrameAncestors are the origins that may embed overlay pages, e.g.
+	// https://example.com. All other pages can't be embedded at all.
+	FrameAncestors []string `json:"frame_ancestors"`
+}
+
+type RateLimitConfig struct {
+	// Login limits login attempts per IP, each one costs a request to Twitch
+	Login Rate `json:"login"`
+	// RoomOpens limits opening chat rooms per session, each one dials EventSub.
+	// Reloading the chat page opens all rooms of the workspace at once.
+	RoomOpens Rate `json:"room_opens"`
+	// RoomOpensPerIP limits opening chat rooms per IP
+	RoomOpensPerIP Rate `json:"room_opens_per_ip"`
+}
+
+// Rate allows PerMinute actions per minute on average and Burst at once. A
+// PerMinute of 0 means unlimited.
+type Rate struct {
+	PerMinute int `json:"per_minute"`
+	Burst     int `json:"burst"`
+}
+
+// defaultPath is read if it exists and no other file was given.
+const defaultPath = "config.json"
+
+func Default() Config {
+	return Config{
+		Listen:          ":8080",
+		ShutdownDelay:   Duration(5 * time.Second),
+		ShutdownTimeout: Duration(10 * time.Second),
+		Session: SessionConfig{
+			Lifetime:    Duration(7 * 24 * time.Hour),
+			IdleTimeout: Duration(24 * time.Hour),
+		},
+		SessionStore: SessionStoreConfig{
+			Backend: "memory",
+			Path:    "truffle.db",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		RateLimit: RateLimitConfig{
+			Login:          Rate{PerMinute: 10, Burst: 5},
+			RoomOpens:      Rate{PerMinute: 10, Burst: 10},
+			RoomOpensPerIP: Rate{PerMinute: 30, Burst: 15},
+		},
+	}
+}
+
+// LoadConfig resolves the configuration from the command line arguments,
+// validates it and makes it available as Conf.
+func LoadConfig(args []string) error {
+	conf, err := Load(args)
+	if err != nil {
+		return err
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return err
+	}
+
+	Conf = conf
+
+	return nil
 }
 
-func LoadConfig() error {
-	jsonFile, err := os.Open("config.json")
+// Load resolves the configuration without validating it. Later sources
+// override earlier ones: defaults, the config file, TRUFFLE_* environment
+// variables and finally flags.
+func Load(args []string) (Config, error) {
+	fs := flag.NewFlagSet("truffle", flag.ContinueOnError)
+	path := fs.String("config", "", "path to the config file (default \""+defaultPath+"\" if it exists)")
+
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		name := s.name
+		fs.Func(s.flag(), s.usage, func(value string) error {
+			flagValues[name] = value
+			return nil
+		})
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	conf := Default()
+
+	err = readFile(&conf, *path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.env())
+		if !ok {
+			continue
+		}
+
+		err = s.set(&conf, value)
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", s.env(), err)
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := flagValues[s.name]
+		if !ok {
+			continue
+		}
+
+		err = s.set(&conf, value)
+		if err != nil {
+			return Config{}, fmt.Errorf("-%s: %w", s.flag(), err)
+		}
+	}
+
+	return conf, nil
+}
+
+func readFile(conf *Config, path string) error {
+	if path == "" {
+		_, err := os.Stat(defaultPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		path = defaultPath
+	}
+
+	jsonFile, err := os.Open(path)
 	if err != nil {
 		return err
 	}
@@ -29,13 +232,189 @@ func LoadConfig() error {
 		return err
 	}
 
-	var conf Config
-	err = json.Unmarshal(jsonBytes, &conf)
+	err = json.Unmarshal(jsonBytes, conf)
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: %w", path, err)
 	}
 
-	Conf = conf
+	return nil
+}
+
+// Validate reports all problems with the configuration at once.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.ClientID == "" {
+		errs = append(errs, errors.New("client_id is missing"))
+	}
+
+	if c.ClientSecret == "" {
+		errs = append(errs, errors.New("client_secret is missing"))
+	}
+
+	errs = append(errs, c.validateURL()...)
+
+	if c.MetricsListen != "" {
+		_, _, err := net.SplitHostPort(c.MetricsListen)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("metrics_listen address %q is malformed: %w", c.MetricsListen, err))
+		} else if c.MetricsListen == c.Listen {
+			errs = append(errs, errors.New("metrics_listen has to differ from listen"))
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file and tls.key_file have to be set together"))
+	}
+
+	_, err := proxy.ParsePrefixes(c.TrustedProxies)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+
+	if c.ShutdownDelay < 0 {
+		errs = append(errs, errors.New("shutdown_delay can't be negative"))
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout has to be positive"))
+	}
+
+	// the cookie signing key should be as long as the HMAC-SHA256 output
+	if c.CookieSecret != "" && len(c.CookieSecret) < 32 {
+		errs = append(errs, errors.New("cookie_secret has to be at least 32 bytes long"))
+	}
+
+	if c.Session.Lifetime <= 0 {
+		errs = append(errs, errors.New("session.lifetime has to be positive"))
+	}
+
+	if c.Session.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("session.idle_timeout has to be positive"))
+	}
+
+	switch c.SessionStore.Backend {
+	case "memory":
+	case "bolt":
+		if c.SessionStore.Path == "" {
+			errs = append(errs, errors.New("session_store.path is missing"))
+		}
+
+		if len(c.TokenKeys) == 0 {
+			errs = append(errs, errors.New("token_keys are required to encrypt tokens in the bolt session store"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("session_store.backend %q is unknown, expected \"memory\" or \"bolt\"", c.SessionStore.Backend))
+	}
+
+	for i, key := range c.TokenKeys {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("token_keys[%d] is not valid base64: %w", i, err))
+		} else if len(raw) != 32 {
+			errs = append(errs, fmt.Errorf("token_keys[%d] has %d bytes, expected 32", i, len(raw)))
+		}
+	}
+
+	for _, origin := range c.Overlay.FrameAncestors {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			errs = append(errs, fmt.Errorf("overlay.frame_ancestors: %q is not an origin like https://example.com", origin))
+		}
+	}
+
+	errs = append(errs, c.RateLimit.Login.validate("rate_limit.login")...)
+	errs = append(errs, c.RateLimit.RoomOpens.validate("rate_limit.room_opens")...)
+	errs = append(errs, c.RateLimit.RoomOpensPerIP.validate("rate_limit.room_opens_per_ip")...)
+
+	var level slog.Level
+	err = level.UnmarshalText([]byte(c.Log.Level))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("log.level %q is unknown, expected \"debug\", \"info\", \"warn\" or \"error\"", c.Log.Level))
+	}
+
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format %q is unknown, expected \"text\" or \"json\"", c.Log.Format))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r Rate) validate(name string) []error {
+	if r.PerMinute < 0 {
+		return []error{fmt.Errorf("%s.per_minute must not be negative", name)}
+	}
+
+	if r.PerMinute > 0 && r.Burst < 1 {
+		return []error{fmt.Errorf("%s.burst has to be at least 1", name)}
+	}
 
 	return nil
 }
+
+// validateURL checks the URL and that it can actually reach the listener,
+// otherwise the OAuth redirect back from Twitch goes nowhere.
+func (c Config) validateURL() []error {
+	if c.URL == "" {
+		return []error{errors.New("url is missing")}
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return []error{fmt.Errorf("url is malformed: %w", err)}
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return []error{fmt.Errorf("url %q is malformed, expected something like https://truffle.example.com", c.URL)}
+	}
+
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return []error{fmt.Errorf("url %q must not have a path, query or fragment", c.URL)}
+	}
+
+	_, listenPort, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return []error{fmt.Errorf("listen address %q is malformed: %w", c.Listen, err)}
+	}
+
+	if u.Scheme == "http" && c.TLS.Enabled() {
+		return []error{fmt.Errorf("url %q uses http, but TLS is enabled", c.URL)}
+	}
+
+	// behind a proxy, scheme and port of the url are the proxy's business
+	if len(c.TrustedProxies) > 0 {
+		return nil
+	}
+
+	if u.Scheme == "https" && !c.TLS.Enabled() {
+		return []error{fmt.Errorf("url %q uses https, but TLS is disabled and there are no trusted_proxies that could terminate it", c.URL)}
+	}
+
+	urlPort := u.Port()
+	if urlPort == "" {
+		urlPort = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+
+	if urlPort != listenPort {
+		return []error{fmt.Errorf("url %q points to port %s, but the server listens on %q", c.URL, urlPort, c.Listen)}
+	}
+
+	return nil
+}
+
+// Print writes the configuration as JSON, with secrets masked.
+func (c Config) Print(w io.Writer) error {
+	masked := c
+	for _, s := range settings {
+		if s.secret && s.get(&masked) != "" {
+			err := s.set(&masked, "********")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(masked)
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a config value that can also be given as environment variable
+// or flag, both are derived from its name.
+type setting struct {
+	name   string
+	usage  string
+	secret bool
+	get    func(c *Config) string
+	set    func(c *Config, value string) error
+}
+
+// env turns session.idle_timeout into TRUFFLE_SESSION_IDLE_TIMEOUT.
+func (s setting) env() string {
+	return "TRUFFLE_" + strings.ToUpper(strings.NewReplacer(".", "_").Replace(s.name))
+}
+
+// flag turns session.idle_timeout into session-idle-timeout.
+func (s setting) flag() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.name)
+}
+
+func stringSetting(name string, usage string, secret bool, field func(c *Config) *string) setting {
+	return setting{
+		name:   name,
+		usage:  usage,
+		secret: secret,
+		get:    func(c *Config) string { return *field(c) },
+		set: func(c *Config, value string) error {
+			*field(c) = value
+			return nil
+		},
+	}
+}
+
+func durationSetting(name string, usage string, field func(c *Config) *Duration) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return time.Duration(*field(c)).String() },
+		set: func(c *Config, value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+
+			*field(c) = Duration(d)
+			return nil
+		},
+	}
+}
+
+func intSetting(name string, usage string, field func(c *Config) *int) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, value string) error {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+
+			*field(c) = i
+			return nil
+		},
+	}
+}
+
+func listSetting(name string, usage string, secret bool, field func(c *Config) *[]string) setting {
+	return setting{
+		name:   name,
+		usage:  usage,
+		secret: secret,
+		get:    func(c *Config) string { return strings.Join(*field(c), ",") },
+		set: func(c *Config, value string) error {
+			*field(c) = nil
+			for _, v := range strings.Split(value, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					*field(c) = append(*field(c), v)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+var settings = []setting{
+	stringSetting("client_id", "Twitch client ID", false, func(c *Config) *string { return &c.ClientID }),
+	stringSetting("client_secret", "Twitch client secret", true, func(c *Config) *string { return &c.ClientSecret }),
+	stringSetting("url", "public URL of Truffle, used for the OAuth redirect", false, func(c *Config) *string { return &c.URL }),
+	stringSetting("listen", "address to listen on", false, func(c *Config) *string { return &c.Listen }),
+	stringSetting("metrics_listen", "separate address to serve /metrics on, metrics are off without it", false, func(c *Config) *string { return &c.MetricsListen }),
+	stringSetting("tls.cert_file", "TLS certificate file", false, func(c *Config) *string { return &c.TLS.CertFile }),
+	stringSetting("tls.key_file", "TLS key file", false, func(c *Config) *string { return &c.TLS.KeyFile }),
+	listSetting("trusted_proxies", "comma separated IPs or CIDR ranges of trusted reverse proxies", false, func(c *Config) *[]string { return &c.TrustedProxies }),
+	durationSetting("shutdown_delay", "how long readiness fails before the server stops accepting requests on shutdown", func(c *Config) *Duration { return &c.ShutdownDelay }),
+	durationSetting("shutdown_timeout", "how long to wait for chat rooms to close on shutdown", func(c *Config) *Duration { return &c.ShutdownTimeout }),
+	stringSetting("cookie_secret", "key for signing session cookies", true, func(c *Config) *string { return &c.CookieSecret }),
+	durationSetting("session.lifetime", "how long a session lasts after login", func(c *Config) *Duration { return &c.Session.Lifetime }),
+	durationSetting("session.idle_timeout", "how long a session lasts without activity", func(c *Config) *Duration { return &c.Session.IdleTimeout }),
+	stringSetting("session_store.backend", "session store, \"memory\" or \"bolt\"", false, func(c *Config) *string { return &c.SessionStore.Backend }),
+	stringSetting("session_store.path", "file of the bolt session store", false, func(c *Config) *string { return &c.SessionStore.Path }),
+	listSetting("token_keys", "comma separated base64 keys for encrypting stored tokens, the first one is current", true, func(c *Config) *[]string { return &c.TokenKeys }),
+	stringSetting("log.level", "minimum log level, \"debug\", \"info\", \"warn\" or \"error\"", false, func(c *Config) *string { return &c.Log.Level }),
+	stringSetting("log.format", "log format, \"text\" or \"json\"", false, func(c *Config) *string { return &c.Log.Format }),
+	listSetting("overlay.frame_ancestors", "comma separated origins that may embed overlay pages", false, func(c *Config) *[]string { return &c.Overlay.FrameAncestors }),
+	intSetting("rate_limit.login.per_minute", "login attempts per minute and IP, 0 for unlimited", func(c *Config) *int { return &c.RateLimit.Login.PerMinute }),
+	intSetting("rate_limit.login.burst", "login attempts an IP may make at once", func(c *Config) *int { return &c.RateLimit.Login.Burst }),
+	intSetting("rate_limit.room_opens.per_minute", "chat rooms opened per minute and session, 0 for unlimited", func(c *Config) *int { return &c.RateLimit.RoomOpens.PerMinute }),
+	intSetting("rate_limit.room_opens.burst", "chat rooms a session may open at once", func(c *Config) *int { return &c.RateLimit.RoomOpens.Burst }),
+	intSetting("rate_limit.room_opens_per_ip.per_minute", "chat rooms opened per minute and IP, 0 for unlimited", func(c *Config) *int { return &c.RateLimit.RoomOpensPerIP.PerMinute }),
+	intSetting("rate_limit.room_opens_per_ip.burst", "chat rooms an IP may open at once", func(c *Config) *int { return &c.RateLimit.RoomOpensPerIP.Burst }),
+}
@@ -0,0 +1,61 @@
+package feature
+
+import (
+	"slices"
+
+	"github.com/m4tthewde/truffle/internal/twitch"
+)
+
+// Feature is a part of Truffle that needs the user to grant certain scopes.
+type Feature struct {
+	ID          string
+	Description string
+	Scopes      []string
+}
+
+var (
+	// Chat is granted on login, everything else is requested on demand.
+	Chat = Feature{
+		ID:          "chat",
+		Description: "Read chat and moderation events",
+		Scopes:      []string{twitch.ScopeReadChat, twitch.ScopeModerate},
+	}
+	SendChat = Feature{
+		ID:          "send-chat",
+		Description: "Send chat messages",
+		Scopes:      []string{twitch.ScopeWriteChat},
+	}
+	AutoMod = Feature{
+		ID:          "automod",
+		Description: "Manage AutoMod",
+		Scopes:      []string{twitch.ScopeManageAutoMod},
+	}
+	Followers = Feature{
+		ID:          "followers",
+		Description: "Read followers",
+		Scopes:      []string{twitch.ScopeReadFollowers},
+	}
+
+	All = []Feature{Chat, SendChat, AutoMod, Followers}
+)
+
+func ByID(id string) (Feature, bool) {
+	for _, f := range All {
+		if f.ID == id {
+			return f, true
+		}
+	}
+
+	return Feature{}, false
+}
+
+// GrantedBy reports whether all scopes of the feature are among scopes.
+func (f Feature) GrantedBy(scopes []string) bool {
+	for _, scope := range f.Scopes {
+		if !slices.Contains(scopes, scope) {
+			return false
+		}
+	}
+
+	return true
+}
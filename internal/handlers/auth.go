@@ -1,13 +1,24 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/a-h/templ"
 	"github.com/google/uuid"
+	"github.com/m4tthewde/truffle/internal/components"
 	"github.com/m4tthewde/truffle/internal/config"
+	"github.com/m4tthewde/truffle/internal/feature"
+	"github.com/m4tthewde/truffle/internal/proxy"
 	"github.com/m4tthewde/truffle/internal/session"
 	"github.com/m4tthewde/truffle/internal/twitch"
 )
@@ -18,16 +29,101 @@ type UserInfo struct {
 	UserID      string
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+const (
+	authURI = "https://id.twitch.tv/oauth2/authorize"
+
+	stateCookieName = "oauthstate"
+	// stateLifetime is how long a user may take to authorize Truffle on Twitch
+	stateLifetime = 10 * time.Minute
+)
+
+// AuthHandler starts a login attempt. The state passed to Twitch is also
+// stored in a short-lived cookie so LoginHandler can tell that the attempt
+// was started by the same browser.
+//
+// Logged in users can pass a feature to request its scopes in addition to
+// the ones they already granted. next is a path to return to after the
+// login, it travels in the state.
+func AuthHandler(w http.ResponseWriter, r *http.Request) {
+	scopes := slices.Clone(feature.Chat.Scopes)
+
+	if id := r.URL.Query().Get("feature"); id != "" {
+		f, ok := feature.ByID(id)
+		if !ok {
+			renderError(w, r, http.StatusBadRequest, "Unknown feature.")
+			return
+		}
+
+		if s, ok := currentSession(r); ok {
+			scopes = append(scopes, s.Scopes...)
+		}
+
+		scopes = append(scopes, f.Scopes...)
+		slices.Sort(scopes)
+		scopes = slices.Compact(scopes)
+	}
+
+	state, err := newState(r.URL.Query().Get("next"))
+	if err != nil {
+		serverError(w, r, err)
 		return
 	}
 
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    state,
+		Path:     "/login",
+		MaxAge:   int(stateLifetime.Seconds()),
+		Secure:   proxy.IsTLS(r),
+		HttpOnly: true,
+		// Lax, the cookie has to survive the top-level redirect back from Twitch
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", config.Conf.ClientID)
+	params.Set("redirect_uri", fmt.Sprintf("%s/login", config.Conf.URL))
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+
+	http.Redirect(w, r, fmt.Sprintf("%s?%s", authURI, params.Encode()), http.StatusFound)
+}
+
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	params, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid login callback", "err", err)
+		renderLoginFailed(w, r, http.StatusBadRequest, "Twitch sent an invalid response.")
+		return
+	}
+
+	// the state is single-use, no matter how this attempt ends
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Path:     "/login",
+		MaxAge:   -1,
+		Secure:   proxy.IsTLS(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	stateCookie, err := r.Cookie(stateCookieName)
+	if err != nil || !validState(stateCookie.Value, params.Get("state")) {
+		slog.WarnContext(r.Context(), "OAuth state mismatch")
+		renderLoginFailed(w, r, http.StatusBadRequest, "The login attempt could not be verified.")
+		return
+	}
+
+	if params.Get("error") != "" {
+		slog.InfoContext(r.Context(), "Authorization failed", "error", params.Get("error"), "description", params.Get("error_description"))
+
+		message := params.Get("error_description")
+		if params.Get("error") == "access_denied" {
+			message = "Truffle needs your permission to read chat on your behalf."
+		}
+
+		renderLoginFailed(w, r, http.StatusOK, message)
 		return
 	}
 
@@ -38,20 +134,103 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		config.Conf.URL,
 	)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Login failed", "err", err)
+		renderLoginFailed(w, r, http.StatusBadRequest, "Twitch did not accept the login.")
 		return
 	}
 
-	validation, err := twitch.ValidateToken(login.AccessToken)
+	validation, err := twitch.ValidateToken(r.Context(), login.AccessToken)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Login failed", "err", err)
+		renderLoginFailed(w, r, http.StatusBadRequest, "Twitch did not accept the login.")
+		return
+	}
+
+	// granting additional scopes keeps the existing session
+	existing, ok := currentSession(r)
+	if ok && existing.UserID == validation.UserID {
+		oldAccessToken := existing.AccessToken
+		err = session.UpdateToken(existing, login, validation.Scopes)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		err = twitch.RevokeToken(ctx, oldAccessToken)
+		if err != nil {
+			slog.WarnContext(ctx, "Revoking the replaced token failed", "err", err)
+		}
+
+		http.Redirect(w, r, config.Conf.URL+returnPath(params.Get("state")), http.StatusFound)
 		return
 	}
 
 	sessionID := uuid.New()
-	s := session.NewSession(sessionID, login.AccessToken, validation.Login, validation.UserID)
-	session.AddSession(s)
-	http.Redirect(w, r, fmt.Sprintf("%s/#%s", config.Conf.URL, sessionID.String()), http.StatusFound)
+	s := session.NewSession(sessionID, login, validation, r)
+	err = session.AddSession(s)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	session.SetCookie(w, r, &s)
+	http.Redirect(w, r, config.Conf.URL+returnPath(params.Get("state")), http.StatusFound)
+}
+
+func renderLoginFailed(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.WriteHeader(status)
+
+	err := components.LoginFailed(templ.URL("/auth"), message).Render(r.Context(), w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Rendering failed", "err", err)
+	}
+}
+
+// newState returns a random state, followed by next if it's a local path.
+// The state cookie has to match it entirely, so next can't be swapped.
+func newState(next string) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	state := base64.RawURLEncoding.EncodeToString(b)
+	if localPath(next) {
+		state += "." + base64.RawURLEncoding.EncodeToString([]byte(next))
+	}
+
+	return state, nil
+}
+
+// returnPath is the path a validated state asks to return to, "/" if none.
+func returnPath(state string) string {
+	_, encoded, ok := strings.Cut(state, ".")
+	if !ok {
+		return "/"
+	}
+
+	next, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !localPath(string(next)) {
+		return "/"
+	}
+
+	return string(next)
+}
+
+// localPath reports whether p is a path on Truffle, not another site like
+// "//example.com".
+func localPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.Contains(p, `\`)
+}
+
+func validState(expected string, actual string) bool {
+	if expected == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
 }
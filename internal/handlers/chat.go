@@ -1,35 +1,130 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/m4tthewde/truffle/internal/components"
 	"github.com/m4tthewde/truffle/internal/session"
+	"github.com/m4tthewde/truffle/internal/twitch"
 )
 
+// maxRooms is how many chat rooms a user can keep open. Each open room holds
+// its own EventSub websocket, also in hidden tabs, and Twitch allows 3 of
+// them per user. The workspace is shared by all of a user's sessions, so
+// with more than one browser open rooms can still hit subscription_limit.
+const maxRooms = 3
+
 func ChatHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	s, _ := currentSession(r)
+
+	workspace, err := session.GetWorkspace(s.UserID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	var active string
+	if len(workspace.Channels) > 0 {
+		active = workspace.Channels[0]
+	}
+
+	component := components.Chat(workspace, active)
+
+	err = component.Render(r.Context(), w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Rendering failed", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// ChatPageHandler renders the full page with a channel's chat room open, so
+// rooms can be bookmarked and reloaded.
+func ChatPageHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := channelParam(w, r, r.PathValue("channel"))
+	if !ok {
 		return
 	}
 
-	_, ok, err := session.SessionFromRequest(r)
+	s, loggedIn := currentSession(r)
+	if !loggedIn {
+		renderPage(w, r, nil)
+		return
+	}
+
+	workspace, err := session.GetWorkspace(s.UserID)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		serverError(w, r, err)
 		return
 	}
 
+	// a GET mustn't change state, the room is only kept once it's opened
+	// with the form. With all rooms taken it's shown instead of the last one.
+	if !workspace.Has(channel) && len(workspace.Channels) >= maxRooms {
+		workspace.Channels = workspace.Channels[:maxRooms-1]
+	}
+
+	workspace.Add(channel)
+
+	renderPage(w, r, components.Chat(workspace, channel))
+}
+
+// CloseRoomHandler removes a room from the user's workspace.
+func CloseRoomHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := channelParam(w, r, r.PathValue("channel"))
 	if !ok {
-		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 
-	component := components.Chat()
+	s, _ := currentSession(r)
 
-	err = component.Render(r.Context(), w)
+	_, err := session.UpdateWorkspace(s.UserID, func(w *session.Workspace) error {
+		w.Remove(channel)
+		return nil
+	})
 	if err != nil {
-		log.Println(err)
+		serverError(w, r, err)
+		return
+	}
+
+	err = components.ClosedRoom(channel).Render(r.Context(), w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Rendering failed", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
+
+// LayoutHandler stores whether rooms are shown as tabs or side by side, the
+// page switches on its own.
+func LayoutHandler(w http.ResponseWriter, r *http.Request) {
+	layout := r.FormValue("layout")
+	if layout != session.LayoutTabs && layout != session.LayoutSplit {
+		renderError(w, r, http.StatusBadRequest, "Unknown layout.")
+		return
+	}
+
+	s, _ := currentSession(r)
+
+	_, err := session.UpdateWorkspace(s.UserID, func(w *session.Workspace) error {
+		w.Layout = layout
+		return nil
+	})
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// channelParam normalizes a channel name given by the user and responds
+// with 400 if it isn't a valid Twitch login.
+func channelParam(w http.ResponseWriter, r *http.Request, channel string) (string, bool) {
+	login, ok := twitch.NormalizeLogin(channel)
+	if !ok {
+		slog.InfoContext(r.Context(), "Invalid channel name", "channel", channel)
+		renderError(w, r, http.StatusBadRequest, "That is not a valid channel name.")
+	}
+
+	return login, ok
+}
@@ -1,49 +1,75 @@
 package handlers
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 
-	"github.com/a-h/templ"
 	"github.com/m4tthewde/truffle/internal/components"
 	"github.com/m4tthewde/truffle/internal/session"
 )
 
-func ChatRoomHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
+var errTooManyRooms = errors.New("too many rooms")
 
-	_, ok, err := session.SessionFromRequest(r)
+// ChatRoomHandler adds a room to the user's workspace and the page, a room
+// that is open already is just shown.
+func ChatRoomHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid form", "err", err)
+		renderError(w, r, http.StatusBadRequest, "The form could not be read.")
 		return
 	}
 
+	channel, ok := channelParam(w, r, r.FormValue("channel"))
 	if !ok {
-		log.Println(err)
-		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 
-	err = r.ParseForm()
+	s, _ := currentSession(r)
+
+	var opened bool
+	_, err = session.UpdateWorkspace(s.UserID, func(w *session.Workspace) error {
+		if w.Has(channel) {
+			return nil
+		}
+
+		if len(w.Channels) >= maxRooms {
+			return errTooManyRooms
+		}
+
+		w.Add(channel)
+		opened = true
+		return nil
+	})
+	if errors.Is(err, errTooManyRooms) {
+		tooManyRooms(w, r)
+		return
+	}
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+		serverError(w, r, err)
 		return
 	}
 
-	channel := r.FormValue("channel")
-	component := components.ChatRoom(
-		channel,
-		templ.Attributes{"ws-connect": "/chat/messages?channel=" + channel},
-	)
+	// the form pushes its own URL, point history at the room's page instead
+	w.Header().Set("HX-Push-Url", components.ChatPath(channel))
+	w.Header().Set("HX-Trigger-After-Settle", fmt.Sprintf(`{"openRoom":%q}`, channel))
+
+	if !opened {
+		w.Header().Set("HX-Reswap", "none")
+		return
+	}
+
+	component := components.OpenedRoom(channel)
 
 	err = component.Render(r.Context(), w)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "Rendering failed", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
+
+func tooManyRooms(w http.ResponseWriter, r *http.Request) {
+	renderError(w, r, http.StatusConflict, fmt.Sprintf("You can have at most %d chat rooms open, please close one first.", maxRooms))
+}
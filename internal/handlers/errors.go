@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/a-h/templ"
+	"github.com/m4tthewde/truffle/internal/components"
+	"github.com/m4tthewde/truffle/internal/logging"
+)
+
+// renderError responds with a message for the user. Requests made by htmx
+// get a fragment that replaces the page's error area, everything else gets
+// a full page.
+func renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	var component templ.Component
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Retarget", "#error")
+		w.Header().Set("HX-Reswap", "innerHTML")
+		component = components.ErrorMessage(message)
+	} else {
+		component = components.ErrorPage(message)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	err := component.Render(r.Context(), w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Rendering error failed", "err", err)
+	}
+}
+
+// serverError logs err and tells the user that something went wrong without
+// going into details.
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	logging.ErrorSkip(r.Context(), 1, "Request failed", err)
+	renderError(w, r, http.StatusInternalServerError, "Something went wrong, please try again.")
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/m4tthewde/truffle/internal/config"
+	"github.com/m4tthewde/truffle/internal/redact"
+	"github.com/m4tthewde/truffle/internal/session"
+	"github.com/m4tthewde/truffle/internal/twitch"
+)
+
+const (
+	// appTokenCheckInterval is how long a successful check of the app
+	// credentials is reused, so frequent probes don't hammer Twitch
+	appTokenCheckInterval = 5 * time.Minute
+	// appTokenRetryInterval is how long a failed check is reused
+	appTokenRetryInterval = 30 * time.Second
+)
+
+var (
+	shuttingDown atomic.Bool
+
+	appToken struct {
+		sync.Mutex
+		token   *twitch.TokenResponse
+		expiry  time.Time
+		checked time.Time
+		err     error
+	}
+)
+
+type check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type eventSubCheck struct {
+	check
+	Connections int        `json:"connections"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+type readiness struct {
+	Ready  bool           `json:"ready"`
+	Checks map[string]any `json:"checks"`
+}
+
+// SetShuttingDown makes the readiness check fail, so no new users are sent
+// to this instance while it drains.
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// HealthHandler reports that the process is alive.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, check{Status: "ok"})
+}
+
+// ReadyHandler reports whether Truffle can serve users. The EventSub readers
+// are reported, but don't affect readiness, a new instance wouldn't fare any
+// better.
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown.Load() {
+		writeJSON(w, r, http.StatusServiceUnavailable, readiness{
+			Checks: map[string]any{"shutdown": check{Status: "failing", Error: "shutting down"}},
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	storeCheck := toCheck(session.Ping())
+	appTokenCheck := toCheck(checkAppToken(ctx))
+
+	readers := twitch.Readers()
+	readerCheck := eventSubCheck{
+		check:       check{Status: "ok"},
+		Connections: readers.Connections,
+	}
+
+	if !readers.Healthy() {
+		readerCheck.Status = "degraded"
+		readerCheck.Error = readers.LastError
+		readerCheck.LastErrorAt = &readers.LastErrorAt
+	}
+
+	status := http.StatusOK
+	ready := storeCheck.Status == "ok" && appTokenCheck.Status == "ok"
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, r, status, readiness{
+		Ready: ready,
+		Checks: map[string]any{
+			"session_store": storeCheck,
+			"app_token":     appTokenCheck,
+			"eventsub":      readerCheck,
+		},
+	})
+}
+
+func toCheck(err error) check {
+	if err != nil {
+		return check{Status: "failing", Error: err.Error()}
+	}
+
+	return check{Status: "ok"}
+}
+
+// checkAppToken verifies the app credentials by obtaining an app access
+// token, or by validating the one obtained before.
+func checkAppToken(ctx context.Context) error {
+	appToken.Lock()
+	defer appToken.Unlock()
+
+	interval := appTokenCheckInterval
+	if appToken.err != nil {
+		interval = appTokenRetryInterval
+	}
+
+	if time.Since(appToken.checked) < interval {
+		return appToken.err
+	}
+
+	appToken.checked = time.Now()
+
+	if appToken.token != nil && time.Until(appToken.expiry) > appTokenCheckInterval {
+		_, err := twitch.ValidateToken(ctx, appToken.token.AccessToken)
+		if !errors.Is(err, twitch.ErrUnauthorized) {
+			appToken.err = err
+			return err
+		}
+	}
+
+	if appToken.token != nil {
+		redact.Remove(appToken.token.AccessToken)
+		appToken.token = nil
+	}
+
+	token, err := twitch.GetAppToken(ctx, config.Conf.ClientID, config.Conf.ClientSecret)
+	appToken.err = err
+	if err != nil {
+		return err
+	}
+
+	redact.Add(token.AccessToken)
+	appToken.token = token
+	appToken.expiry = token.Expiry()
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Writing JSON failed", "err", err)
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/m4tthewde/truffle/internal/session"
+	"github.com/m4tthewde/truffle/internal/twitch"
+)
+
+// joinOutcome is how joining a chat room ended, it's also the label of
+// roomJoinsTotal.
+type joinOutcome string
+
+const (
+	joined                  joinOutcome = "joined"
+	joinedWithoutModeration joinOutcome = "joined_without_moderation"
+	channelNotFound         joinOutcome = "channel_not_found"
+	tokenExpired            joinOutcome = "token_expired"
+	sessionEnded            joinOutcome = "session_ended"
+	subscriptionLimit       joinOutcome = "subscription_limit"
+	twitchRateLimited       joinOutcome = "twitch_rate_limited"
+	chatForbidden           joinOutcome = "chat_forbidden"
+	rateLimited             joinOutcome = "rate_limited"
+	joinFailed              joinOutcome = "failed"
+)
+
+// joinFailure returns the outcome of joining that failed with err.
+func joinFailure(err error) joinOutcome {
+	switch {
+	case errors.Is(err, twitch.ErrChannelNotFound):
+		return channelNotFound
+	case errors.Is(err, twitch.ErrUnauthorized), errors.Is(err, session.ErrTokenExpired):
+		return tokenExpired
+	case errors.Is(err, session.ErrSessionEnded):
+		return sessionEnded
+	case errors.Is(err, twitch.ErrSubscriptionLimit):
+		return subscriptionLimit
+	case errors.Is(err, twitch.ErrRateLimited):
+		return twitchRateLimited
+	case errors.Is(err, twitch.ErrChatForbidden):
+		return chatForbidden
+	default:
+		return joinFailed
+	}
+}
+
+// message tells the user about the outcome in the room's status line, kind
+// is the class it's shown with.
+func (o joinOutcome) message(channel string) (message string, kind string) {
+	switch o {
+	case joinedWithoutModeration:
+		return "You are not a moderator of #" + channel + ", bans and timeouts aren't shown.", "warning"
+	case channelNotFound:
+		return "There is no channel called " + channel + ".", "error"
+	case tokenExpired:
+		return "Your Twitch login has expired, please log in again.", "error"
+	case sessionEnded:
+		return "Your session has ended, please log in again.", "error"
+	case subscriptionLimit:
+		return "Twitch doesn't allow you to open more chat rooms, please close one and try again.", "error"
+	case chatForbidden:
+		return "You are not allowed to read the chat of #" + channel + ".", "error"
+	case rateLimited:
+		return "You opened too many chat rooms, retrying in a moment...", "warning"
+	case twitchRateLimited:
+		return "Twitch is getting too many requests, retrying in a moment...", "warning"
+	case joinFailed:
+		return "Joining the chat failed, retrying...", "error"
+	default:
+		return "", "muted"
+	}
+}
+
+// retry reports whether htmx should reconnect, the other failures won't go
+// away on their own.
+func (o joinOutcome) retry() bool {
+	return o == joinFailed || o == rateLimited || o == twitchRateLimited
+}
@@ -1,44 +1,18 @@
 package handlers
 
 import (
-	"context"
-	"log"
 	"net/http"
-	"time"
 
 	"github.com/m4tthewde/truffle/internal/session"
-	"github.com/m4tthewde/truffle/internal/twitch"
 )
 
+// LogoutHandler ends the session, which also closes its chat rooms in other
+// tabs, and revokes its token.
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
+	s, _ := currentSession(r)
 
-	s, ok, err := session.SessionFromRequest(r)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	if !ok {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-
-	session.DeleteSession(s)
-
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	err = twitch.RevokeToken(ctx, s.AccessToken)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+	session.Revoke(s, session.ErrLoggedOut)
+	session.ClearCookie(w, r)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	openRooms = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "truffle_chat_rooms_open",
+		Help: "Chat rooms currently open in browsers.",
+	})
+
+	roomJoinsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "truffle_chat_room_joins_total",
+		Help: "Attempts to join a chat room by outcome.",
+	}, []string{"outcome"})
+
+	rateLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "truffle_rate_limited_total",
+		Help: "Requests rejected by rate limits, by limit.",
+	}, []string{"limit"})
+
+	wsWriteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "truffle_websocket_write_duration_seconds",
+		Help:    "Time it takes to write a message to a browser websocket.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	})
+)
+
+// writeMessage sends a text message to the browser and records how long it
+// took.
+func writeMessage(c *websocket.Conn, data []byte) error {
+	start := time.Now()
+	err := c.WriteMessage(websocket.TextMessage, data)
+	wsWriteDuration.Observe(time.Since(start).Seconds())
+
+	return err
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/m4tthewde/truffle/internal/logging"
+	"github.com/m4tthewde/truffle/internal/proxy"
+	"github.com/m4tthewde/truffle/internal/session"
+)
+
+// CSRFHeader carries the CSRF token, htmx sends it with every request.
+const CSRFHeader = "X-CSRF-Token"
+
+type contextKey int
+
+const (
+	sessionKey contextKey = iota
+	nonceKey
+)
+
+// unloggedPaths are polled by load balancers and orchestrators, logging them
+// would drown out everything else.
+var unloggedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// LogRequests logs every request along with the address of the client,
+// except for probes. It assigns each request an ID that is added to
+// everything logged with the request's context and sent back in the
+// X-Request-ID header.
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestID()
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := logging.With(r.Context(), slog.String("request_id", id))
+		r = r.WithContext(ctx)
+
+		if !unloggedPaths[r.URL.Path] {
+			slog.InfoContext(ctx, "Request", "method", r.Method, "path", r.URL.Path, "client_ip", proxy.ClientIP(r))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// loadSession adds the session of the request to its context, if there is
+// one. Use currentSession to get it. Requests that may change state have to
+// carry the session's CSRF token in the CSRFHeader, otherwise they are
+// rejected.
+func loadSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok, err := session.SessionFromRequest(w, r)
+		if errors.Is(err, session.ErrInvalidCookie) {
+			// a tampered or outdated cookie, treat the user as logged out
+			slog.WarnContext(r.Context(), "Ignoring session cookie", "err", err)
+		} else if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		if ok && !safeMethod(r.Method) && !session.ValidCSRFToken(s, r.Header.Get(CSRFHeader)) {
+			slog.WarnContext(r.Context(), "Invalid CSRF token", "user_id", s.UserID)
+			renderError(w, r, http.StatusForbidden, "The request could not be verified, please reload the page.")
+			return
+		}
+
+		if ok {
+			ctx := context.WithValue(r.Context(), sessionKey, s)
+			ctx = logging.With(ctx, slog.String("user_id", s.UserID))
+			r = r.WithContext(ctx)
+		}
+
+		next(w, r)
+	}
+}
+
+// requireSession is like loadSession, but rejects requests of users that
+// aren't logged in.
+func requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return loadSession(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := currentSession(r); !ok {
+			renderError(w, r, http.StatusUnauthorized, "You are not logged in.")
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// currentSession returns the session added by loadSession.
+func currentSession(r *http.Request) (*session.Session, bool) {
+	s, ok := r.Context().Value(sessionKey).(*session.Session)
+	return s, ok
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/m4tthewde/truffle/internal/config"
+	"github.com/m4tthewde/truffle/internal/proxy"
+	"github.com/m4tthewde/truffle/internal/ratelimit"
+)
+
+// rateLimit is a limit on how often a client may use a route, key tells
+// clients apart.
+type rateLimit struct {
+	name    string
+	limiter *ratelimit.Limiter
+	key     func(r *http.Request) string
+	// message tells the user why the request was rejected
+	message string
+}
+
+func newRateLimit(name string, rate config.Rate, key func(r *http.Request) string, message string) rateLimit {
+	return rateLimit{
+		name:    name,
+		limiter: ratelimit.New(rate.PerMinute, rate.Burst),
+		key:     key,
+		message: message,
+	}
+}
+
+// limited rejects requests with 429 once one of the limits is exhausted.
+func limited(next http.HandlerFunc, limits ...rateLimit) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, retryAfter, ok := allow(r, limits)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			renderError(w, r, http.StatusTooManyRequests, l.message)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// allow takes a token from each limit, if one is exhausted it's returned
+// along with when to try again.
+func allow(r *http.Request, limits []rateLimit) (rateLimit, time.Duration, bool) {
+	for _, l := range limits {
+		ok, retryAfter := l.limiter.Allow(l.key(r))
+		if ok {
+			continue
+		}
+
+		slog.WarnContext(r.Context(), "Rate limited", "limit", l.name)
+		rateLimitedTotal.WithLabelValues(l.name).Inc()
+
+		return l, retryAfter, false
+	}
+
+	return rateLimit{}, 0, true
+}
+
+func byIP(r *http.Request) string {
+	return proxy.ClientIP(r)
+}
+
+// bySession has to run after requireSession.
+func bySession(r *http.Request) string {
+	s, _ := currentSession(r)
+	return s.ID.String()
+}
+
+// wsRoomLimits limit the room websockets, WsChatHandler checks them after
+// the upgrade, so the user is told about it. Router sets them up.
+var wsRoomLimits []rateLimit
+
+// roomLimits returns fresh limits on opening chat rooms.
+func roomLimits() []rateLimit {
+	message := "You opened too many chat rooms, please wait a moment."
+	return []rateLimit{
+		newRateLimit("room_opens_per_ip", config.Conf.RateLimit.RoomOpensPerIP, byIP, message),
+		newRateLimit("room_opens", config.Conf.RateLimit.RoomOpens, bySession, message),
+	}
+}
@@ -2,31 +2,47 @@ package handlers
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 
 	"github.com/a-h/templ"
 	"github.com/m4tthewde/truffle/internal/components"
-	"github.com/m4tthewde/truffle/internal/config"
 	"github.com/m4tthewde/truffle/internal/session"
 )
 
-const authURITemplate = "https://id.twitch.tv/oauth2/authorize?response_type=code&client_id=%s&redirect_uri=%s/login&scope=user:read:chat channel:moderate"
-
 func RootHandler(w http.ResponseWriter, r *http.Request) {
-	_, loggedIn, err := session.SessionFromRequest(r)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	renderPage(w, r, nil)
+}
+
+// renderPage renders the full page with main in the main area, logged out
+// users get the login link instead.
+func renderPage(w http.ResponseWriter, r *http.Request, main templ.Component) {
+	s, loggedIn := currentSession(r)
+
+	var csrfToken string
+	if loggedIn {
+		csrfToken = session.CSRFToken(s)
+	}
+
+	var notice string
+	if !loggedIn {
+		if reason := session.EndReason(r); reason != nil {
+			notice = fmt.Sprintf("You were logged out, %s.", reason)
+		}
+	}
+
+	authURI := "/auth"
+	if r.URL.Path != "/" {
+		// bring the user back to the page after logging in
+		authURI += "?" + url.Values{"next": {r.URL.Path}}.Encode()
 	}
 
-	authURI := fmt.Sprintf(authURITemplate, config.Conf.ClientID, config.Conf.URL)
-	component := components.Root(loggedIn, templ.URL(authURI))
+	component := components.Root(nonce(r), csrfToken, loggedIn, templ.URL(authURI), notice, main)
 
-	err = component.Render(r.Context(), w)
+	err := component.Render(r.Context(), w)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "Rendering failed", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/m4tthewde/truffle/internal/config"
+	"github.com/m4tthewde/truffle/internal/static"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Router returns the handler for all of Truffle's routes. The configuration
+// has to be loaded already.
+func Router() http.Handler {
+	mux := http.NewServeMux()
+
+	login := newRateLimit("login", config.Conf.RateLimit.Login, byIP, "Too many login attempts, please wait a moment.")
+	wsRoomLimits = roomLimits()
+
+	mux.HandleFunc("GET /{$}", loadSession(RootHandler))
+	mux.HandleFunc("GET /auth", loadSession(AuthHandler))
+	mux.HandleFunc("GET /login", limited(loadSession(LoginHandler), login))
+	mux.HandleFunc("POST /logout", requireSession(LogoutHandler))
+
+	mux.HandleFunc("GET /chat", requireSession(ChatHandler))
+	// the websocket checks wsRoomLimits itself, after the upgrade
+	mux.HandleFunc("POST /chatroom", requireSession(limited(ChatRoomHandler, roomLimits()...)))
+	mux.HandleFunc("GET /chat/{channel}", loadSession(ChatPageHandler))
+	mux.HandleFunc("POST /chat/{channel}/close", requireSession(CloseRoomHandler))
+	mux.HandleFunc("POST /chat/layout", requireSession(LayoutHandler))
+	mux.HandleFunc("GET /chat/{channel}/messages", requireSession(WsChatHandler))
+
+	mux.HandleFunc("GET /settings", requireSession(SettingsHandler))
+	mux.HandleFunc("POST /sessions/revoke", requireSession(RevokeSessionHandler))
+	mux.HandleFunc("POST /sessions/revoke-all", requireSession(RevokeAllSessionsHandler))
+	mux.HandleFunc("POST /session/keepalive", requireSession(KeepAliveHandler))
+
+	mux.Handle("GET /static/", static.Handler())
+
+	mux.HandleFunc("GET /healthz", HealthHandler)
+	mux.HandleFunc("GET /readyz", ReadyHandler)
+
+	return mux
+}
+
+// MetricsRouter serves the Prometheus metrics. It's meant for its own
+// listener, so they aren't exposed with the rest of Truffle.
+func MetricsRouter() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	return mux
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/m4tthewde/truffle/internal/config"
+	"github.com/m4tthewde/truffle/internal/proxy"
+)
+
+// SecurityHeaders sets a strict Content-Security-Policy and related headers
+// on every response. Scripts only run if they carry the request's nonce,
+// pages pass it to the components with the nonce function.
+func SecurityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := newNonce()
+		r = r.WithContext(context.WithValue(r.Context(), nonceKey, n))
+
+		h := w.Header()
+		h.Set("Content-Security-Policy", contentSecurityPolicy(n, "'none'"))
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+
+		if proxy.IsTLS(r) {
+			h.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AllowFraming opts a route, like a stream overlay, into being embedded by
+// the origins in overlay.frame_ancestors.
+func AllowFraming(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ancestors := "'none'"
+		if len(config.Conf.Overlay.FrameAncestors) > 0 {
+			ancestors = strings.Join(config.Conf.Overlay.FrameAncestors, " ")
+		}
+
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy(nonce(r), ancestors))
+		// X-Frame-Options can't name origins, frame-ancestors supersedes it
+		w.Header().Del("X-Frame-Options")
+
+		next(w, r)
+	}
+}
+
+func contentSecurityPolicy(nonce string, frameAncestors string) string {
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src 'nonce-" + nonce + "' 'strict-dynamic'",
+		"style-src 'self'",
+		// chatters pick their own name color
+		"style-src-attr 'unsafe-inline'",
+		"object-src 'none'",
+		"base-uri 'none'",
+		"form-action 'self'",
+		"frame-ancestors " + frameAncestors,
+	}, "; ")
+}
+
+// nonce returns the nonce scripts of the response have to carry.
+func nonce(r *http.Request) string {
+	n, _ := r.Context().Value(nonceKey).(string)
+	return n
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/m4tthewde/truffle/internal/components"
+	"github.com/m4tthewde/truffle/internal/session"
+)
+
+// RevokeSessionHandler ends one of the user's other sessions.
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	s, _ := currentSession(r)
+
+	id, err := uuid.Parse(r.FormValue("id"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Unknown session.")
+		return
+	}
+
+	target, ok, err := session.GetSession(id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	// sessions of other users are treated as if they didn't exist
+	if ok && target.UserID == s.UserID {
+		session.Revoke(target, session.ErrSessionRevoked)
+	}
+
+	sessions, err := session.UserSessions(s.UserID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	err = components.ActiveSessions(s.ID, sessions).Render(r.Context(), w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Rendering failed", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// RevokeAllSessionsHandler logs the user out on every device, including
+// the one making the request.
+func RevokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	s, _ := currentSession(r)
+
+	sessions, err := session.UserSessions(s.UserID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	for _, other := range sessions {
+		session.Revoke(&other, session.ErrSessionRevoked)
+	}
+
+	session.ClearCookie(w, r)
+	w.Header().Set("HX-Redirect", "/")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// KeepAliveHandler marks the session as active, it's used to dismiss the
+// idle warning.
+func KeepAliveHandler(w http.ResponseWriter, r *http.Request) {
+	err := components.IdleWarning(false).Render(r.Context(), w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Rendering failed", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -1,35 +1,28 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/m4tthewde/truffle/internal/components"
+	"github.com/m4tthewde/truffle/internal/feature"
 	"github.com/m4tthewde/truffle/internal/session"
 )
 
 func SettingsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
+	s, _ := currentSession(r)
 
-	_, ok, err := session.SessionFromRequest(r)
+	sessions, err := session.UserSessions(s.UserID)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	if !ok {
-		w.WriteHeader(http.StatusForbidden)
+		serverError(w, r, err)
 		return
 	}
 
-	component := components.Settings()
+	component := components.Settings(feature.All, s, sessions)
 
 	err = component.Render(r.Context(), w)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "Rendering failed", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
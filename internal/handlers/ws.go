@@ -3,94 +3,184 @@ package handlers
 import (
 	"bytes"
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/a-h/templ"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/m4tthewde/truffle/internal/components"
+	"github.com/m4tthewde/truffle/internal/config"
+	"github.com/m4tthewde/truffle/internal/logging"
+	"github.com/m4tthewde/truffle/internal/room"
 	"github.com/m4tthewde/truffle/internal/session"
 	"github.com/m4tthewde/truffle/internal/twitch"
 )
 
-var upgrader = websocket.Upgrader{}
+var upgrader = websocket.Upgrader{CheckOrigin: checkOrigin}
 
-// WsChatHandler FIXME: this sometimes takes very long (10+ seconds) to connect
+const (
+	idleCheckInterval = 30 * time.Second
+	// idleWarning is how long before an idle timeout the user gets warned
+	idleWarning = 5 * time.Minute
+)
+
+// WsChatHandler upgrades to a websocket right away and joins the channel
+// while the room shows how far it got.
 func WsChatHandler(w http.ResponseWriter, r *http.Request) {
-	s, ok, err := session.SessionFromRequest(r)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if !checkOrigin(r) {
+		slog.WarnContext(r.Context(), "Rejected websocket from foreign origin", "origin", r.Header.Get("Origin"))
+		renderError(w, r, http.StatusForbidden, "Chat rooms can only be opened from Truffle.")
 		return
 	}
 
+	channel, ok := channelParam(w, r, r.PathValue("channel"))
 	if !ok {
-		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
+	s, _ := currentSession(r)
 
-	channelID, err := twitch.GetChannelID(ctx, s.AccessToken, r.FormValue("channel"))
+	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Upgrading to websocket failed", "err", err)
 		return
 	}
 
-	ctx, cancel = context.WithCancel(r.Context())
-	defer cancel()
+	defer c.Close()
 
-	conn := make(chan twitch.Payload)
-	go twitch.Read(s.AccessToken, twitch.NewCondition(channelID, s.UserID), conn, ctx)
+	openRooms.Inc()
+	defer openRooms.Dec()
 
-	c, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println(err)
+	// a 429 before the upgrade would never be shown by htmx
+	if _, _, ok := allow(r, wsRoomLimits); !ok {
+		roomJoinsTotal.WithLabelValues(string(rateLimited)).Inc()
+		failJoin(r.Context(), c, channel, rateLimited)
 		return
 	}
 
-	defer c.Close()
+	ctx, rm := room.Open(r.Context(), s.ID, channel)
+	defer rm.Close()
 
-	var connectBuffer bytes.Buffer
-	component := components.ConnectMessage()
-	err = component.Render(ctx, &connectBuffer)
+	go func(rm *room.Room) {
+		for {
+			_, _, err := c.ReadMessage()
+			if err != nil {
+				rm.Cancel()
+				return
+			}
+		}
+	}(rm)
+
+	err = writeComponent(ctx, c, components.RoomStatus(channel, stepStatus[twitch.StepResolving], "muted"))
 	if err != nil {
-		log.Println(err)
+		slog.InfoContext(ctx, "Writing to websocket failed", "err", err)
 		return
 	}
 
-	err = c.WriteMessage(websocket.TextMessage, connectBuffer.Bytes())
+	tokens := session.TokenSource(s.ID)
+
+	resolveCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	channelID, err := twitch.ResolveChannel(resolveCtx, tokens, channel)
+	cancel()
 	if err != nil {
-		log.Println(err)
+		slog.WarnContext(ctx, "Looking up channel failed", "err", err)
+
+		outcome := joinFailure(err)
+		roomJoinsTotal.WithLabelValues(string(outcome)).Inc()
+		failJoin(ctx, c, channel, outcome)
 		return
 	}
 
-	go func(cancel context.CancelFunc) {
-		for {
-			_, _, err := c.ReadMessage()
-			if err != nil {
-				cancel()
-				return
-			}
-		}
-	}(cancel)
+	ctx = logging.With(ctx, slog.String("broadcaster_id", channelID))
+
+	conn := make(chan twitch.Payload)
+	progress := make(chan twitch.Progress)
+	isJoined := false
+	go twitch.Read(tokens, twitch.NewCondition(channelID, s.UserID), conn, progress, ctx)
 
 	// if we don't send a ping, htmx reconnects for no reason
 	// htmx uses 100s as interval
 	pingTicker := time.NewTicker(1 * time.Minute)
 
+	// watching chat doesn't count as activity, warn before the session ends
+	idleTicker := time.NewTicker(idleCheckInterval)
+	idleWarned := false
+
 	for {
 		select {
 		case <-pingTicker.C:
-			if c.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Println(err)
+			if err = c.WriteMessage(websocket.PingMessage, nil); err != nil {
+				slog.InfoContext(ctx, "Writing to websocket failed", "err", err)
+				return
+			}
+
+		case p := <-progress:
+			if p.Err != nil {
+				outcome := joinFailure(p.Err)
+				// once joined, a failure doesn't count as a join attempt
+				if !isJoined {
+					roomJoinsTotal.WithLabelValues(string(outcome)).Inc()
+				}
+
+				failJoin(ctx, c, channel, outcome)
+				return
+			}
+
+			if p.Step == twitch.StepJoined {
+				isJoined = true
+				err = joinRoom(ctx, c, channel, p.Moderation)
+			} else {
+				err = writeComponent(ctx, c, components.RoomStatus(channel, stepStatus[p.Step], "muted"))
+			}
+			if err != nil {
+				slog.InfoContext(ctx, "Writing to websocket failed", "err", err)
+				return
+			}
+
+		case <-idleTicker.C:
+			idleWarned, err = checkIdle(ctx, c, s.ID, idleWarned)
+			if err != nil {
+				slog.ErrorContext(ctx, "Checking idle timeout failed", "err", err)
 				return
 			}
 
 		case payload, ok := <-conn:
 			if !ok {
-				log.Println("Reader closed connection")
+				slog.InfoContext(ctx, "Reader closed connection", "cause", context.Cause(ctx))
+
+				reason := context.Cause(ctx)
+				if reason == nil || errors.Is(reason, context.Canceled) {
+					return
+				}
+
+				var templateBuffer bytes.Buffer
+				if errors.Is(reason, room.ErrShutdown) {
+					err = components.ServerRestartingMessage(channel).Render(ctx, &templateBuffer)
+				} else {
+					// the room was closed because the session was terminated
+					err = components.SessionEndedMessage(reason.Error()).Render(ctx, &templateBuffer)
+				}
+				if err != nil {
+					slog.ErrorContext(ctx, "Rendering failed", "err", err)
+					return
+				}
+
+				err = writeMessage(c, templateBuffer.Bytes())
+				if err != nil {
+					slog.InfoContext(ctx, "Writing to websocket failed", "err", err)
+					return
+				}
+
+				if errors.Is(reason, room.ErrShutdown) {
+					// htmx reconnects on 1012, hopefully to the restarted server
+					closeSocket(ctx, c, websocket.CloseServiceRestart, reason.Error())
+				}
+
 				return
 			}
 
@@ -98,29 +188,33 @@ func WsChatHandler(w http.ResponseWriter, r *http.Request) {
 			switch payload.Subscription.Type {
 			case twitch.MessageType:
 				component := components.Message(
+					channel,
 					time.Now(),
 					templ.Attributes{"style": "color:" + payload.Event.Color},
 					payload.Event.ChatterUserName,
 					payload.Event.ChatMessage.Text,
+					mentions(payload.Event.ChatMessage.Text, s.Login),
 				)
-				if component.Render(ctx, &templateBuffer); err != nil {
-					log.Println(err)
+				if err = component.Render(ctx, &templateBuffer); err != nil {
+					slog.ErrorContext(ctx, "Rendering failed", "err", err)
 					return
 				}
 			case twitch.UnbanType:
 				component := components.UnbanMessage(
+					channel,
 					time.Now(),
 					payload.Event.ModeratorUserLogin,
 					payload.Event.UserLogin,
 				)
 
-				if component.Render(ctx, &templateBuffer); err != nil {
-					log.Println(err)
+				if err = component.Render(ctx, &templateBuffer); err != nil {
+					slog.ErrorContext(ctx, "Rendering failed", "err", err)
 					return
 				}
 
 			case twitch.BanType:
 				component := components.BanMessage(
+					channel,
 					payload.Event.BannedAt,
 					payload.Event.IsPermanent,
 					payload.Event.ModeratorUserLogin,
@@ -128,17 +222,130 @@ func WsChatHandler(w http.ResponseWriter, r *http.Request) {
 					payload.Event.Reason,
 					payload.Event.EndsAt.Sub(payload.Event.BannedAt),
 				)
-				if component.Render(ctx, &templateBuffer); err != nil {
-					log.Println(err)
+				if err = component.Render(ctx, &templateBuffer); err != nil {
+					slog.ErrorContext(ctx, "Rendering failed", "err", err)
 					return
 				}
 
 			}
 
-			if c.WriteMessage(websocket.TextMessage, templateBuffer.Bytes()); err != nil {
-				log.Println(err)
+			if err = writeMessage(c, templateBuffer.Bytes()); err != nil {
+				slog.InfoContext(ctx, "Writing to websocket failed", "err", err)
 				return
 			}
 		}
 	}
 }
+
+// stepStatus tells the user what's happening while a room is joined.
+var stepStatus = map[twitch.Step]string{
+	twitch.StepResolving:   "Looking up the channel...",
+	twitch.StepConnecting:  "Connecting to Twitch...",
+	twitch.StepWelcome:     "Waiting for Twitch...",
+	twitch.StepSubscribing: "Subscribing to chat...",
+}
+
+// joinRoom tells the user that the room was joined, and whether bans and
+// timeouts are missing.
+func joinRoom(ctx context.Context, c *websocket.Conn, channel string, moderation bool) error {
+	outcome := joined
+	if !moderation {
+		outcome = joinedWithoutModeration
+	}
+
+	roomJoinsTotal.WithLabelValues(string(outcome)).Inc()
+
+	message, kind := outcome.message(channel)
+	err := writeComponent(ctx, c, components.RoomStatus(channel, message, kind))
+	if err != nil {
+		return err
+	}
+
+	return writeComponent(ctx, c, components.ConnectMessage(channel))
+}
+
+// failJoin shows why the room couldn't be joined and closes the websocket.
+// htmx only reconnects for failures that may go away.
+func failJoin(ctx context.Context, c *websocket.Conn, channel string, outcome joinOutcome) {
+	message, kind := outcome.message(channel)
+	err := writeComponent(ctx, c, components.RoomStatus(channel, message, kind))
+	if err != nil {
+		slog.InfoContext(ctx, "Writing to websocket failed", "err", err)
+		return
+	}
+
+	code := websocket.CloseNormalClosure
+	if outcome.retry() {
+		code = websocket.CloseTryAgainLater
+	}
+
+	closeSocket(ctx, c, code, string(outcome))
+}
+
+// writeComponent renders component and sends it to the browser.
+func writeComponent(ctx context.Context, c *websocket.Conn, component templ.Component) error {
+	var buf bytes.Buffer
+	err := component.Render(ctx, &buf)
+	if err != nil {
+		return err
+	}
+
+	return writeMessage(c, buf.Bytes())
+}
+
+// closeSocket tells the browser why the websocket is closed, the code
+// decides whether htmx reconnects.
+func closeSocket(ctx context.Context, c *websocket.Conn, code int, text string) {
+	err := c.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, text),
+		time.Now().Add(time.Second),
+	)
+	if err != nil {
+		slog.InfoContext(ctx, "Sending close frame failed", "err", err)
+	}
+}
+
+// checkOrigin only lets Truffle's own pages open websockets, otherwise any
+// site could read chat with the cookie of a logged in user.
+func checkOrigin(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Origin"), config.Conf.URL)
+}
+
+// mentions reports whether text addresses login, with or without an @.
+func mentions(text string, login string) bool {
+	for _, word := range strings.Fields(text) {
+		word = strings.TrimPrefix(word, "@")
+		word = strings.TrimRightFunc(word, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+		})
+
+		if strings.EqualFold(word, login) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIdle shows or hides the idle warning in the room and returns whether
+// it is shown now.
+func checkIdle(ctx context.Context, c *websocket.Conn, sessionID uuid.UUID, warned bool) (bool, error) {
+	s, ok, err := session.GetSession(sessionID)
+	if err != nil || !ok {
+		return warned, err
+	}
+
+	warn := time.Until(s.IdleDeadline()) <= idleWarning
+	if warn == warned {
+		return warned, nil
+	}
+
+	var templateBuffer bytes.Buffer
+	err = components.IdleWarning(warn).Render(ctx, &templateBuffer)
+	if err != nil {
+		return warned, err
+	}
+
+	return warn, writeMessage(c, templateBuffer.Bytes())
+}
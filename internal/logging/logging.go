@@ -0,0 +1,106 @@
+// Package logging sets up structured logging and carries attributes like
+// request and room IDs through contexts, so that every record logged with
+// such a context can be correlated.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+)
+
+type contextKey struct{}
+
+// Init makes a logger writing to w in the given level and format the
+// default. Records of the log package end up there as well.
+func Init(w io.Writer, level string, format string) error {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(level))
+	if err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       l,
+		ReplaceAttr: shortSource,
+	}
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+
+	return nil
+}
+
+// shortSource logs file:line instead of the full path and function name.
+func shortSource(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.SourceKey || len(groups) > 0 {
+		return a
+	}
+
+	source, ok := a.Value.Any().(*slog.Source)
+	if !ok {
+		return a
+	}
+
+	return slog.String(slog.SourceKey, fmt.Sprintf("%s:%d", filepath.Base(source.File), source.Line))
+}
+
+// With returns a copy of ctx whose log records carry attrs in addition to
+// the ones added before.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	return context.WithValue(ctx, contextKey{}, append(slices.Clip(existing), attrs...))
+}
+
+// ErrorSkip logs err like slog.ErrorContext, but attributes the record to
+// the caller skip frames above the one calling ErrorSkip. It's meant for
+// helpers that log on behalf of their callers.
+func ErrorSkip(ctx context.Context, skip int, msg string, err error) {
+	if !slog.Default().Enabled(ctx, slog.LevelError) {
+		return
+	}
+
+	var pcs [1]uintptr
+	// skip runtime.Callers, ErrorSkip and the helper
+	runtime.Callers(skip+2, pcs[:])
+
+	r := slog.NewRecord(time.Now(), slog.LevelError, msg, pcs[0])
+	r.AddAttrs(slog.Any("err", err))
+	_ = slog.Default().Handler().Handle(ctx, r)
+}
+
+// contextHandler adds the attributes stored in the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var trusted []netip.Prefix
+
+// Init sets the addresses of reverse proxies whose X-Forwarded-* headers are
+// honored. Entries are either single IPs or CIDR ranges.
+func Init(trustedProxies []string) error {
+	prefixes, err := ParsePrefixes(trustedProxies)
+	if err != nil {
+		return err
+	}
+
+	trusted = prefixes
+	return nil
+}
+
+func ParsePrefixes(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+func isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr, true
+}
+
+// ClientIP returns the address of the client. Behind trusted proxies it is
+// the last address in X-Forwarded-For that isn't a trusted proxy itself.
+func ClientIP(r *http.Request) string {
+	remote, ok := remoteAddr(r)
+	if !ok {
+		return r.RemoteAddr
+	}
+
+	if !isTrusted(remote) {
+		return remote.Unmap().String()
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+
+		if !isTrusted(addr) {
+			return addr.Unmap().String()
+		}
+	}
+
+	return remote.Unmap().String()
+}
+
+// IsTLS reports whether the client connected via TLS, either directly or to
+// a trusted proxy.
+func IsTLS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+
+	remote, ok := remoteAddr(r)
+	if !ok || !isTrusted(remote) {
+		return false
+	}
+
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
@@ -0,0 +1,84 @@
+// Package ratelimit limits how often clients may do something expensive,
+// separately for each key like an IP address or a session.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// cleanupInterval is how often buckets that are full again are dropped.
+const cleanupInterval = 1 * time.Minute
+
+// Limiter is a token bucket per key, filling up at perMinute tokens per
+// minute up to burst.
+type Limiter struct {
+	limit rate.Limit
+	burst int
+
+	mu          sync.Mutex
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// New returns a Limiter, a perMinute of 0 disables it.
+func New(perMinute int, burst int) *Limiter {
+	return &Limiter{
+		limit:   rate.Limit(float64(perMinute) / 60),
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of key. If there is none, it returns
+// false and how long it takes until there is one.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.limit == 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+
+	b.lastUsed = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// cleanup drops buckets that had enough time to fill up, they are no
+// different from new ones.
+func (l *Limiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < cleanupInterval {
+		return
+	}
+
+	l.lastCleanup = now
+	full := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.lastUsed) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
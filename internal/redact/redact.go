@@ -0,0 +1,63 @@
+package redact
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+const placeholder = "[redacted]"
+
+var (
+	mu      sync.RWMutex
+	secrets = make(map[string]struct{})
+)
+
+// Add registers a secret that must never show up in logs.
+func Add(secret string) {
+	// an empty secret would match everywhere
+	if secret == "" {
+		return
+	}
+
+	mu.Lock()
+	secrets[secret] = struct{}{}
+	mu.Unlock()
+}
+
+func Remove(secret string) {
+	mu.Lock()
+	delete(secrets, secret)
+	mu.Unlock()
+}
+
+// String replaces all registered secrets in s.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for secret := range secrets {
+		s = strings.ReplaceAll(s, secret, placeholder)
+	}
+
+	return s
+}
+
+// Writer redacts everything written to it before passing it on, it's meant
+// to be used as the output of the log package.
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	_, err := io.WriteString(w.w, String(string(p)))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,110 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/m4tthewde/truffle/internal/logging"
+)
+
+var ErrShutdown = errors.New("the server is restarting")
+
+// Room is an open chat room, i.e. a browser websocket that is fed by an
+// EventSub reader.
+type Room struct {
+	// ID identifies the room in logs
+	ID        string
+	SessionID uuid.UUID
+	Channel   string
+	cancel    context.CancelCauseFunc
+	closeOnce sync.Once
+}
+
+var (
+	mu    sync.Mutex
+	rooms = make(map[*Room]struct{})
+	// open counts rooms whose handlers haven't returned yet
+	open sync.WaitGroup
+)
+
+// Open registers a new room. The returned context is cancelled once the room
+// is closed, context.Cause tells why. Records logged with it carry the room's
+// ID and channel.
+func Open(ctx context.Context, sessionID uuid.UUID, channel string) (context.Context, *Room) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	r := &Room{
+		ID:        uuid.NewString(),
+		SessionID: sessionID,
+		Channel:   channel,
+		cancel:    cancel,
+	}
+
+	mu.Lock()
+	rooms[r] = struct{}{}
+	mu.Unlock()
+	open.Add(1)
+
+	ctx = logging.With(ctx, slog.String("room_id", r.ID), slog.String("channel", channel))
+
+	return ctx, r
+}
+
+// Cancel cancels the room's context, e.g. because the browser went away.
+// The handler still has to Close the room.
+func (r *Room) Cancel() {
+	r.cancel(nil)
+}
+
+// Close unregisters the room and cancels its context, the handler calls it
+// once it is done with the room.
+func (r *Room) Close() {
+	r.closeOnce.Do(func() {
+		mu.Lock()
+		delete(rooms, r)
+		mu.Unlock()
+
+		r.cancel(nil)
+		open.Done()
+	})
+}
+
+// CloseSession closes all rooms of a session, reason is shown to the user.
+func CloseSession(sessionID uuid.UUID, reason error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for r := range rooms {
+		if r.SessionID == sessionID {
+			r.cancel(reason)
+		}
+	}
+}
+
+// CloseAll closes every room, reason is shown to the users.
+func CloseAll(reason error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for r := range rooms {
+		r.cancel(reason)
+	}
+}
+
+// Wait blocks until all rooms are closed or ctx is done.
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		open.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,264 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	sessionBucket   = []byte("sessions")
+	workspaceBucket = []byte("workspaces")
+)
+
+// boltStore persists sessions in a bbolt file so they survive restarts.
+// Tokens are encrypted before they are written to disk.
+type boltStore struct {
+	db   *bolt.DB
+	keys keyring
+}
+
+func newBoltStore(path string, keys keyring) (*boltStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("the bolt session store needs token_keys to encrypt tokens")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(workspaceBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	b := &boltStore{db: db, keys: keys}
+	err = b.rekey()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// rekey re-encrypts tokens that were sealed with an old key and drops
+// sessions that no configured key can decrypt.
+func (b *boltStore) rekey() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionBucket)
+
+		updated := make(map[string][]byte)
+		var dropped [][]byte
+		err := bucket.ForEach(func(k, data []byte) error {
+			s, stale, err := b.keys.decode(data)
+			if errors.Is(err, ErrUnknownKey) {
+				dropped = append(dropped, k)
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if stale {
+				data, err = b.keys.encode(s)
+				if err != nil {
+					return err
+				}
+
+				updated[string(k)] = data
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, data := range updated {
+			err = bucket.Put([]byte(k), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, k := range dropped {
+			err = bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(updated) > 0 || len(dropped) > 0 {
+			slog.Info("Re-encrypted stored sessions", "updated", len(updated), "dropped", len(dropped))
+		}
+
+		return nil
+	})
+}
+
+func (b *boltStore) Get(id uuid.UUID) (Session, bool, error) {
+	var s Session
+	var ok bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionBucket).Get(id[:])
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		s, _, err = b.keys.decode(data)
+		ok = err == nil
+		return err
+	})
+
+	return s, ok, err
+}
+
+func (b *boltStore) Put(s Session) error {
+	data, err := b.keys.encode(s)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionBucket).Put(s.ID[:], data)
+	})
+}
+
+func (b *boltStore) Update(id uuid.UUID, fn func(s *Session) error) (Session, error) {
+	var s Session
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionBucket)
+		data := bucket.Get(id[:])
+		if data == nil {
+			return ErrNotFound
+		}
+
+		var err error
+		s, _, err = b.keys.decode(data)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&s)
+		if err != nil {
+			return err
+		}
+
+		data, err = b.keys.encode(s)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(id[:], data)
+	})
+
+	return s, err
+}
+
+func (b *boltStore) Delete(id uuid.UUID) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionBucket).Delete(id[:])
+	})
+}
+
+func (b *boltStore) All() ([]Session, error) {
+	var all []Session
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionBucket).ForEach(func(_, data []byte) error {
+			s, _, err := b.keys.decode(data)
+			if err != nil {
+				return err
+			}
+
+			all = append(all, s)
+			return nil
+		})
+	})
+
+	return all, err
+}
+
+func (b *boltStore) GetWorkspace(userID string) (Workspace, error) {
+	var w Workspace
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		w, err = getWorkspace(tx, userID)
+		return err
+	})
+
+	return w, err
+}
+
+func (b *boltStore) UpdateWorkspace(userID string, fn func(w *Workspace) error) (Workspace, error) {
+	var w Workspace
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		w, err = getWorkspace(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&w)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(w)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(workspaceBucket).Put([]byte(userID), data)
+	})
+
+	return w, err
+}
+
+// getWorkspace reads a workspace, which isn't secret and stored unencrypted.
+func getWorkspace(tx *bolt.Tx, userID string) (Workspace, error) {
+	var w Workspace
+	data := tx.Bucket(workspaceBucket).Get([]byte(userID))
+	if data != nil {
+		err := json.Unmarshal(data, &w)
+		if err != nil {
+			return Workspace{}, err
+		}
+	}
+
+	return w.clone(), nil
+}
+
+func (b *boltStore) Ping() error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(sessionBucket) == nil {
+			return errors.New("the sessions bucket is missing")
+		}
+
+		if tx.Bucket(workspaceBucket) == nil {
+			return errors.New("the workspaces bucket is missing")
+		}
+
+		return nil
+	})
+}
+
+func (b *boltStore) Close() error {
+	return b.db.Close()
+}
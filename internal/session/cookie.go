@@ -0,0 +1,100 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m4tthewde/truffle/internal/config"
+	"github.com/m4tthewde/truffle/internal/proxy"
+)
+
+const cookieName = "sessionid"
+
+var (
+	cookieKey []byte
+
+	ErrInvalidCookie = errors.New("invalid session cookie")
+)
+
+func initCookieKey() error {
+	if config.Conf.CookieSecret != "" {
+		cookieKey = []byte(config.Conf.CookieSecret)
+		return nil
+	}
+
+	slog.Warn("No cookie_secret configured, using a random one. Sessions will not survive restarts")
+	cookieKey = make([]byte, 32)
+	_, err := rand.Read(cookieKey)
+
+	return err
+}
+
+// SetCookie hands the session to the browser in a signed cookie that is not
+// accessible to scripts. The cookie expires together with the session, so it
+// is set again whenever the session was used.
+func SetCookie(w http.ResponseWriter, r *http.Request, s *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    sign(s.ID),
+		Path:     "/",
+		MaxAge:   int(time.Until(s.ExpiresAt()).Seconds()),
+		Secure:   proxy.IsTLS(r),
+		HttpOnly: true,
+		// Lax instead of Strict, otherwise the redirect chain that started on
+		// Twitch during login would arrive without the cookie
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   proxy.IsTLS(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func sign(id uuid.UUID) string {
+	return id.String() + "." + base64.RawURLEncoding.EncodeToString(mac(id))
+}
+
+func mac(id uuid.UUID) []byte {
+	h := hmac.New(sha256.New, cookieKey)
+	h.Write([]byte(id.String()))
+	return h.Sum(nil)
+}
+
+func sessionIDFromRequest(r *http.Request) (uuid.UUID, bool, error) {
+	sessionCookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return uuid.Nil, false, nil
+	}
+
+	value, signature, found := strings.Cut(sessionCookie.Value, ".")
+	if !found {
+		return uuid.Nil, false, ErrInvalidCookie
+	}
+
+	sessionID, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, false, ErrInvalidCookie
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decoded, mac(sessionID)) {
+		return uuid.Nil, false, ErrInvalidCookie
+	}
+
+	return sessionID, true, nil
+}
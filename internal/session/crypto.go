@@ -0,0 +1,191 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const keyIDSize = 4
+
+var ErrUnknownKey = errors.New("token was encrypted with an unknown key")
+
+// tokenKey is an AEAD key, identified by a short hash so that sealed tokens
+// remember which key they were sealed with.
+type tokenKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// keyring holds the keys for token encryption. The first key encrypts, all
+// of them decrypt, which allows rotating keys without losing sessions.
+type keyring []tokenKey
+
+func newKeyring(encoded []string) (keyring, error) {
+	var keys keyring
+	for i, e := range encoded {
+		raw, err := base64.StdEncoding.DecodeString(e)
+		if err != nil {
+			return nil, fmt.Errorf("token key %d: %w", i, err)
+		}
+
+		if len(raw) != 32 {
+			return nil, fmt.Errorf("token key %d: expected 32 bytes, got %d", i, len(raw))
+		}
+
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(raw)
+		keys = append(keys, tokenKey{id: sum[:keyIDSize], aead: aead})
+	}
+
+	return keys, nil
+}
+
+// seal encrypts plaintext with the current key, the session ID is bound to
+// the ciphertext so tokens can't be swapped between sessions.
+func (k keyring) seal(plaintext string, sessionID uuid.UUID) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	key := k[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	out := append([]byte{}, key.id...)
+	out = append(out, nonce...)
+	out = key.aead.Seal(out, nonce, []byte(plaintext), sessionID[:])
+
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// open decrypts a sealed token. stale reports whether it was sealed with a
+// key other than the current one and should be sealed again.
+func (k keyring) open(sealed string, sessionID uuid.UUID) (plaintext string, stale bool, err error) {
+	if sealed == "" {
+		return "", false, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", false, err
+	}
+
+	if len(raw) < keyIDSize {
+		return "", false, ErrUnknownKey
+	}
+
+	for i, key := range k {
+		if string(key.id) != string(raw[:keyIDSize]) {
+			continue
+		}
+
+		nonceSize := key.aead.NonceSize()
+		if len(raw) < keyIDSize+nonceSize {
+			return "", false, errors.New("sealed token too short")
+		}
+
+		nonce := raw[keyIDSize : keyIDSize+nonceSize]
+		decrypted, err := key.aead.Open(nil, nonce, raw[keyIDSize+nonceSize:], sessionID[:])
+		if err != nil {
+			return "", false, err
+		}
+
+		return string(decrypted), i != 0, nil
+	}
+
+	return "", false, ErrUnknownKey
+}
+
+// sealedSession is how a session is persisted, without plaintext tokens.
+type sealedSession struct {
+	ID           uuid.UUID `json:"id"`
+	Created      time.Time `json:"created"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`
+	Scopes       []string  `json:"scopes"`
+	Login        string    `json:"login"`
+	UserID       string    `json:"user_id"`
+	UserAgent    string    `json:"user_agent"`
+	IP           string    `json:"ip"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+func (k keyring) encode(s Session) ([]byte, error) {
+	accessToken, err := k.seal(s.AccessToken, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := k.seal(s.RefreshToken, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(sealedSession{
+		ID:           s.ID,
+		Created:      s.Created,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		Expiry:       s.Expiry,
+		Scopes:       s.Scopes,
+		Login:        s.Login,
+		UserID:       s.UserID,
+		UserAgent:    s.UserAgent,
+		IP:           s.IP,
+		LastSeen:     s.LastSeen,
+	})
+}
+
+func (k keyring) decode(data []byte) (s Session, stale bool, err error) {
+	var sealed sealedSession
+	err = json.Unmarshal(data, &sealed)
+	if err != nil {
+		return Session{}, false, err
+	}
+
+	accessToken, staleAccess, err := k.open(sealed.AccessToken, sealed.ID)
+	if err != nil {
+		return Session{}, false, err
+	}
+
+	refreshToken, staleRefresh, err := k.open(sealed.RefreshToken, sealed.ID)
+	if err != nil {
+		return Session{}, false, err
+	}
+
+	return Session{
+		ID:           sealed.ID,
+		Created:      sealed.Created,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		Expiry:       sealed.Expiry,
+		Scopes:       sealed.Scopes,
+		Login:        sealed.Login,
+		UserID:       sealed.UserID,
+		UserAgent:    sealed.UserAgent,
+		IP:           sealed.IP,
+		LastSeen:     sealed.LastSeen,
+	}, staleAccess || staleRefresh, nil
+}
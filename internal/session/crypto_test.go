@@ -0,0 +1,157 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+func testKey(t *testing.T) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func testKeyring(t *testing.T, encoded ...string) keyring {
+	keys, err := newKeyring(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keys
+}
+
+func TestOpenWithOldKey(t *testing.T) {
+	a, b := testKey(t), testKey(t)
+	id := uuid.New()
+
+	sealed, err := testKeyring(t, a).seal("access", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, stale, err := testKeyring(t, b, a).open(sealed, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plaintext != "access" {
+		t.Errorf("plaintext = %q, want %q", plaintext, "access")
+	}
+
+	if !stale {
+		t.Error("token sealed with an old key isn't stale")
+	}
+
+	_, stale, err = testKeyring(t, a, b).open(sealed, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stale {
+		t.Error("token sealed with the current key is stale")
+	}
+}
+
+func TestOpenSwappedSession(t *testing.T) {
+	keys := testKeyring(t, testKey(t))
+
+	sealed, err := keys.seal("access", uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = keys.open(sealed, uuid.New())
+	if err == nil {
+		t.Error("token of another session was opened")
+	}
+}
+
+// reopen closes the store and opens its file again with other keys.
+func reopen(t *testing.T, store *boltStore, path string, keys keyring) *boltStore {
+	err := store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = newBoltStore(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRekey(t *testing.T) {
+	a, b := testKey(t), testKey(t)
+	path := filepath.Join(t.TempDir(), "truffle.db")
+
+	store, err := newBoltStore(path, testKeyring(t, a))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := testSession()
+	err = store.Put(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store = reopen(t, store, path, testKeyring(t, b, a))
+
+	// only the new key is needed once the session was re-encrypted
+	err = store.db.View(func(tx *bolt.Tx) error {
+		got, stale, err := testKeyring(t, b).decode(tx.Bucket(sessionBucket).Get(s.ID[:]))
+		if err != nil {
+			return err
+		}
+
+		if stale {
+			t.Error("re-encrypted session is stale")
+		}
+
+		if got.AccessToken != s.AccessToken || got.RefreshToken != s.RefreshToken {
+			t.Errorf("tokens = %q, %q, want %q, %q", got.AccessToken, got.RefreshToken, s.AccessToken, s.RefreshToken)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRekeyDropsUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "truffle.db")
+
+	store, err := newBoltStore(path, testKeyring(t, testKey(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := testSession()
+	err = store.Put(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store = reopen(t, store, path, testKeyring(t, testKey(t)))
+
+	_, ok, err := store.Get(s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok {
+		t.Error("session sealed with an unknown key wasn't dropped")
+	}
+}
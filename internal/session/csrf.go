@@ -0,0 +1,22 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// CSRFToken returns the token that requests changing state on behalf of s
+// have to carry. It is derived from the session ID with the cookie key, so
+// it lasts as long as the session and is useless for any other one.
+func CSRFToken(s *Session) string {
+	h := hmac.New(sha256.New, cookieKey)
+	// separated from the cookie signature, which is a MAC of the bare ID
+	h.Write([]byte("csrf:" + s.ID.String()))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// ValidCSRFToken reports whether token belongs to s.
+func ValidCSRFToken(s *Session, token string) bool {
+	return hmac.Equal([]byte(token), []byte(CSRFToken(s)))
+}
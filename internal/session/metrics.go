@@ -0,0 +1,12 @@
+package session
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// activeSessions is updated by CleanupTicker.
+var activeSessions = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "truffle_sessions_active",
+	Help: "Sessions that are neither expired nor idle, updated every minute.",
+})
@@ -0,0 +1,110 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m4tthewde/truffle/internal/config"
+	"github.com/m4tthewde/truffle/internal/twitch"
+)
+
+// refreshMargin is how long before its expiry an access token gets refreshed.
+const refreshMargin = 5 * time.Minute
+
+// refreshToken exchanges refresh tokens, tests replace it.
+var refreshToken = twitch.RefreshToken
+
+// refreshLocks makes sure a session is only refreshed by one goroutine at a time.
+var refreshLocks sync.Map
+
+func (s *Session) NeedsRefresh() bool {
+	return time.Until(s.Expiry) < refreshMargin
+}
+
+// Refresh exchanges the refresh token of s for a new access token and stores
+// the result. If Twitch no longer accepts the refresh token, the session is
+// deleted and the user has to log in again.
+func Refresh(ctx context.Context, s *Session) error {
+	lock, _ := refreshLocks.LoadOrStore(s.ID, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, ok, err := GetSession(s.ID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrSessionEnded
+	}
+
+	// somebody else refreshed the token while we were waiting for the lock
+	if current.AccessToken != s.AccessToken {
+		*s = *current
+		return nil
+	}
+
+	token, err := refreshToken(ctx, current.RefreshToken, config.Conf.ClientID, config.Conf.ClientSecret)
+	if err != nil {
+		if errors.Is(err, twitch.ErrInvalidRefreshToken) {
+			Terminate(s, ErrTokenExpired)
+			return ErrTokenExpired
+		}
+
+		return err
+	}
+
+	return UpdateToken(s, token, token.Scope)
+}
+
+type tokenSource struct {
+	id uuid.UUID
+}
+
+// TokenSource returns a twitch.TokenSource that always reads the latest
+// tokens of the session with the given ID.
+func TokenSource(id uuid.UUID) twitch.TokenSource {
+	return tokenSource{id: id}
+}
+
+func (t tokenSource) Token(ctx context.Context) (string, error) {
+	s, ok, err := GetSession(t.id)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return "", ErrSessionEnded
+	}
+
+	if s.NeedsRefresh() {
+		err = Refresh(ctx, s)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return s.AccessToken, nil
+}
+
+func (t tokenSource) Refresh(ctx context.Context) (string, error) {
+	s, ok, err := GetSession(t.id)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return "", ErrSessionEnded
+	}
+
+	err = Refresh(ctx, s)
+	if err != nil {
+		return "", err
+	}
+
+	return s.AccessToken, nil
+}
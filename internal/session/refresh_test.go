@@ -0,0 +1,60 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m4tthewde/truffle/internal/twitch"
+)
+
+func TestConcurrentRefresh(t *testing.T) {
+	store = newMemoryStore()
+	ended = make(map[uuid.UUID]endedSession)
+
+	var calls atomic.Int32
+	refreshToken = func(ctx context.Context, token string, clientID string, clientSecret string) (*twitch.TokenResponse, error) {
+		calls.Add(1)
+		// give the other goroutines time to queue up behind the lock
+		time.Sleep(10 * time.Millisecond)
+
+		return &twitch.TokenResponse{AccessToken: "new access", RefreshToken: "new refresh", ExpiresIn: 3600}, nil
+	}
+	t.Cleanup(func() { refreshToken = twitch.RefreshToken })
+
+	s := testSession()
+	err := AddSession(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// every caller holds the same stale copy
+			stale := s
+			err := Refresh(context.Background(), &stale)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if stale.AccessToken != "new access" {
+				t.Errorf("AccessToken = %q, want the refreshed token", stale.AccessToken)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("token endpoint called %d times, want 1", got)
+	}
+}
@@ -1,80 +1,351 @@
 package session
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"strings"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/m4tthewde/truffle/internal/config"
+	"github.com/m4tthewde/truffle/internal/proxy"
+	"github.com/m4tthewde/truffle/internal/redact"
+	"github.com/m4tthewde/truffle/internal/room"
+	"github.com/m4tthewde/truffle/internal/twitch"
+)
+
+// lastSeenInterval limits how often the last activity of a session is written.
+const lastSeenInterval = 1 * time.Minute
+
+var (
+	store Store
+	// ended remembers why sessions were terminated so the user can be told
+	ended   map[uuid.UUID]endedSession
+	endedMu sync.Mutex
 )
 
 var (
-	sessions map[uuid.UUID]Session
+	ErrSessionEnded   = errors.New("your session has ended")
+	ErrSessionExpired = errors.New("your session expired")
+	ErrSessionIdle    = errors.New("you were inactive for too long")
+	ErrSessionRevoked = errors.New("the session was ended from another device")
+	ErrLoggedOut      = errors.New("you logged out")
+	ErrTokenExpired   = errors.New("your Twitch login has expired")
+	ErrTokenRevoked   = errors.New("your Twitch authorization was revoked")
 )
 
-func Init() {
-	sessions = make(map[uuid.UUID]Session)
+type endedSession struct {
+	reason error
+	at     time.Time
+}
+
+// Init sets up the session store configured in config.Conf.
+func Init() error {
+	keys, err := newKeyring(config.Conf.TokenKeys)
+	if err != nil {
+		return err
+	}
+
+	store, err = newStore(config.Conf.SessionStore, keys)
+	if err != nil {
+		return err
+	}
+
+	// sessions loaded from disk may be logged before they are used
+	all, err := store.All()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range all {
+		registerSecrets(&s)
+	}
+
+	ended = make(map[uuid.UUID]endedSession)
+
+	return initCookieKey()
+}
+
+func Close() error {
+	return store.Close()
+}
+
+// Ping reports whether the session store is usable.
+func Ping() error {
+	return store.Ping()
 }
 
 func CleanupTicker() {
 	ticker := time.NewTicker(1 * time.Minute)
 	for {
 		<-ticker.C
-		for _, s := range sessions {
-			if time.Since(s.Created).Hours() >= 7*24 {
-				DeleteSession(&s)
+		all, err := store.All()
+		if err != nil {
+			slog.Error("Listing sessions failed", "err", err)
+			continue
+		}
+
+		active := 0
+		for _, s := range all {
+			if reason := s.expired(); reason != nil {
+				Revoke(&s, reason)
+			} else {
+				active++
 			}
 		}
+
+		activeSessions.Set(float64(active))
+
+		endedMu.Lock()
+		for id, e := range ended {
+			if time.Since(e.at).Hours() >= 24 {
+				delete(ended, id)
+			}
+		}
+		endedMu.Unlock()
+	}
+}
+
+// Revoke terminates a session and also revokes its token, as nothing is
+// going to use it anymore.
+func Revoke(s *Session, reason error) {
+	Terminate(s, reason)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := twitch.RevokeToken(ctx, s.AccessToken)
+	if err != nil {
+		slog.Warn("Revoking token failed", "user_id", s.UserID, "err", err)
 	}
 }
 
 type Session struct {
-	ID          uuid.UUID
-	Created     time.Time
-	AccessToken string
-	Login       string
-	UserID      string
+	ID           uuid.UUID
+	Created      time.Time
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+	Scopes       []string
+	Login        string
+	UserID       string
+	UserAgent    string
+	IP           string
+	LastSeen     time.Time
 }
 
-func NewSession(id uuid.UUID, accessToken string, login string, userID string) Session {
+func NewSession(id uuid.UUID, token *twitch.TokenResponse, validation *twitch.ValidationResponse, r *http.Request) Session {
 	return Session{
-		ID:          id,
-		Created:     time.Now(),
-		AccessToken: accessToken,
-		Login:       login,
-		UserID:      userID,
+		ID:           id,
+		Created:      time.Now(),
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry(),
+		Scopes:       validation.Scopes,
+		Login:        validation.Login,
+		UserID:       validation.UserID,
+		UserAgent:    r.UserAgent(),
+		IP:           proxy.ClientIP(r),
+		LastSeen:     time.Now(),
+	}
+}
+
+// IdleDeadline is when the session ends unless it is used again.
+func (s *Session) IdleDeadline() time.Time {
+	return s.LastSeen.Add(time.Duration(config.Conf.Session.IdleTimeout))
+}
+
+// ExpiresAt is when the session ends, due to either timeout.
+func (s *Session) ExpiresAt() time.Time {
+	absolute := s.Created.Add(time.Duration(config.Conf.Session.Lifetime))
+	idle := s.IdleDeadline()
+	if idle.Before(absolute) {
+		return idle
+	}
+
+	return absolute
+}
+
+// expired returns why the session is over, or nil if it isn't.
+func (s *Session) expired() error {
+	if time.Since(s.Created) >= time.Duration(config.Conf.Session.Lifetime) {
+		return ErrSessionExpired
 	}
+
+	if time.Now().After(s.IdleDeadline()) {
+		return ErrSessionIdle
+	}
+
+	return nil
+}
+
+// String leaves out the tokens, so sessions can be logged.
+func (s Session) String() string {
+	return fmt.Sprintf("session of %s (%s)", s.Login, s.UserID)
+}
+
+// LogValue keeps the ID and tokens of sessions out of structured logs.
+func (s Session) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("user_id", s.UserID), slog.String("login", s.Login))
+}
+
+// registerSecrets makes sure the tokens of s are redacted from logs.
+func registerSecrets(s *Session) {
+	redact.Add(s.AccessToken)
+	redact.Add(s.RefreshToken)
 }
 
-func AddSession(session Session) {
-	sessions[session.ID] = session
+func (s Session) clone() Session {
+	s.Scopes = slices.Clone(s.Scopes)
+	return s
 }
 
-func DeleteSession(session *Session) {
-	delete(sessions, session.ID)
+func AddSession(session Session) error {
+	registerSecrets(&session)
+	return store.Put(session)
 }
 
-func SessionFromRequest(r *http.Request) (*Session, bool, error) {
-	sessionCookie, err := r.Cookie("sessionid")
+// UpdateToken replaces the tokens of an existing session, e.g. after the
+// user granted additional scopes. session is updated to the stored state.
+func UpdateToken(session *Session, token *twitch.TokenResponse, scopes []string) error {
+	redact.Add(token.AccessToken)
+	redact.Add(token.RefreshToken)
+
+	updated, err := store.Update(session.ID, func(s *Session) error {
+		redact.Remove(s.AccessToken)
+		redact.Remove(s.RefreshToken)
+		s.AccessToken = token.AccessToken
+		s.RefreshToken = token.RefreshToken
+		s.Expiry = token.Expiry()
+		s.Scopes = scopes
+		return nil
+	})
 	if err != nil {
-		return nil, false, nil
+		return err
 	}
 
-	splits := strings.Split(sessionCookie.String(), "=")
-	if len(splits) < 2 {
-		return nil, false, errors.New("invalid cookie format")
+	*session = updated
+	return nil
+}
+
+func DeleteSession(session *Session) error {
+	refreshLocks.Delete(session.ID)
+	err := store.Delete(session.ID)
+	if err != nil {
+		return err
 	}
 
-	sessionID, err := uuid.Parse(splits[1])
+	redact.Remove(session.AccessToken)
+	redact.Remove(session.RefreshToken)
+	return nil
+}
+
+// Terminate deletes the session and closes its chat rooms, reason is shown to
+// the user.
+func Terminate(session *Session, reason error) {
+	slog.Info("Terminating session", "user_id", session.UserID, "reason", reason)
+	err := DeleteSession(session)
 	if err != nil {
+		slog.Error("Deleting session failed", "user_id", session.UserID, "err", err)
+	}
+
+	endedMu.Lock()
+	ended[session.ID] = endedSession{reason: reason, at: time.Now()}
+	endedMu.Unlock()
+
+	room.CloseSession(session.ID, reason)
+}
+
+func GetSession(id uuid.UUID) (*Session, bool, error) {
+	s, ok, err := store.Get(id)
+	if !ok || err != nil {
 		return nil, false, err
 	}
 
-	s, ok := sessions[sessionID]
-	if !ok {
+	registerSecrets(&s)
+	return &s, true, nil
+}
+
+// SessionFromRequest returns the session of the request's cookie. Using a
+// session counts as activity, so its last-seen time and cookie are renewed.
+func SessionFromRequest(w http.ResponseWriter, r *http.Request) (*Session, bool, error) {
+	sessionID, ok, err := sessionIDFromRequest(r)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	s, ok, err := GetSession(sessionID)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	// the cleanup may not have caught up yet
+	if s.expired() != nil {
 		return nil, false, nil
 	}
 
-	return &s, true, nil
+	if time.Since(s.LastSeen) >= lastSeenInterval {
+		updated, err := store.Update(s.ID, func(s *Session) error {
+			s.LastSeen = time.Now()
+			s.IP = proxy.ClientIP(r)
+			s.UserAgent = r.UserAgent()
+			return nil
+		})
+		if errors.Is(err, ErrNotFound) {
+			return nil, false, nil
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		*s = updated
+		SetCookie(w, r, s)
+	}
+
+	return s, true, nil
+}
+
+// UserSessions returns all sessions of a user, most recently used first.
+func UserSessions(userID string) ([]Session, error) {
+	all, err := store.All()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	for _, s := range all {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+
+	slices.SortFunc(sessions, func(a, b Session) int {
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+
+	return sessions, nil
+}
+
+// EndReason returns why the session referenced by the request was
+// terminated, or nil if it wasn't.
+func EndReason(r *http.Request) error {
+	sessionID, ok, err := sessionIDFromRequest(r)
+	if !ok || err != nil {
+		return nil
+	}
+
+	endedMu.Lock()
+	e, ok := ended[sessionID]
+	endedMu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return e.reason
 }
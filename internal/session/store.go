@@ -0,0 +1,144 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/m4tthewde/truffle/internal/config"
+)
+
+var ErrNotFound = errors.New("session not found")
+
+// Store keeps sessions, Get and All return copies. Implementations have to be
+// safe for concurrent use.
+type Store interface {
+	Get(id uuid.UUID) (Session, bool, error)
+	Put(s Session) error
+	// Update atomically applies fn to the stored session and returns the
+	// result, ErrNotFound if there is no such session.
+	Update(id uuid.UUID, fn func(s *Session) error) (Session, error)
+	Delete(id uuid.UUID) error
+	All() ([]Session, error)
+	// GetWorkspace returns an empty workspace for users without one.
+	GetWorkspace(userID string) (Workspace, error)
+	// UpdateWorkspace atomically applies fn to the workspace of userID.
+	UpdateWorkspace(userID string, fn func(w *Workspace) error) (Workspace, error)
+	// Ping reports whether the store is usable.
+	Ping() error
+	Close() error
+}
+
+func newStore(conf config.SessionStoreConfig, keys keyring) (Store, error) {
+	switch conf.Backend {
+	case "", "memory":
+		return newMemoryStore(), nil
+	case "bolt":
+		path := conf.Path
+		if path == "" {
+			path = "truffle.db"
+		}
+
+		return newBoltStore(path, keys)
+	default:
+		return nil, fmt.Errorf("unknown session store backend %q", conf.Backend)
+	}
+}
+
+type memoryStore struct {
+	mu         sync.RWMutex
+	sessions   map[uuid.UUID]Session
+	workspaces map[string]Workspace
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		sessions:   make(map[uuid.UUID]Session),
+		workspaces: make(map[string]Workspace),
+	}
+}
+
+func (m *memoryStore) Get(id uuid.UUID) (Session, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[id]
+	return s.clone(), ok, nil
+}
+
+func (m *memoryStore) Put(s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = s.clone()
+	return nil
+}
+
+func (m *memoryStore) Update(id uuid.UUID, fn func(s *Session) error) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+
+	s = s.clone()
+	err := fn(&s)
+	if err != nil {
+		return Session{}, err
+	}
+
+	m.sessions[id] = s
+	return s.clone(), nil
+}
+
+func (m *memoryStore) Delete(id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memoryStore) All() ([]Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	all := make([]Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		all = append(all, s.clone())
+	}
+
+	return all, nil
+}
+
+func (m *memoryStore) GetWorkspace(userID string) (Workspace, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.workspaces[userID].clone(), nil
+}
+
+func (m *memoryStore) UpdateWorkspace(userID string, fn func(w *Workspace) error) (Workspace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := m.workspaces[userID].clone()
+	err := fn(&w)
+	if err != nil {
+		return Workspace{}, err
+	}
+
+	m.workspaces[userID] = w
+	return w.clone(), nil
+}
+
+func (m *memoryStore) Ping() error {
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
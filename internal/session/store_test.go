@@ -0,0 +1,148 @@
+package session
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func testStores(t *testing.T) map[string]Store {
+	bolt, err := newBoltStore(filepath.Join(t.TempDir(), "truffle.db"), testKeyring(t, testKey(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { bolt.Close() })
+
+	return map[string]Store{
+		"memory": newMemoryStore(),
+		"bolt":   bolt,
+	}
+}
+
+func testSession() Session {
+	return Session{
+		ID:           uuid.New(),
+		Created:      time.Now(),
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		UserID:       "1",
+		LastSeen:     time.Now(),
+	}
+}
+
+func TestStoreConcurrentUpdate(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := testSession()
+			err := store.Put(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const n = 50
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					_, err := store.Update(s.ID, func(s *Session) error {
+						s.Scopes = append(s.Scopes, strconv.Itoa(i))
+						return nil
+					})
+					if err != nil {
+						t.Error(err)
+					}
+
+					_, _, err = store.Get(s.ID)
+					if err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+
+			wg.Wait()
+
+			got, ok, err := store.Get(s.ID)
+			if err != nil || !ok {
+				t.Fatalf("Get() = %v, %v", ok, err)
+			}
+
+			if len(got.Scopes) != n {
+				t.Errorf("got %d scopes, want %d, updates were lost", len(got.Scopes), n)
+			}
+		})
+	}
+}
+
+func TestStoreConcurrentAccess(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			const n = 20
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					s := testSession()
+					err := store.Put(s)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					_, err = store.Update(s.ID, func(s *Session) error {
+						s.LastSeen = time.Now()
+						return nil
+					})
+					if err != nil {
+						t.Error(err)
+					}
+
+					_, err = store.All()
+					if err != nil {
+						t.Error(err)
+					}
+
+					err = store.Delete(s.ID)
+					if err != nil {
+						t.Error(err)
+					}
+
+					_, ok, err := store.Get(s.ID)
+					if err != nil || ok {
+						t.Errorf("Get() after Delete() = %v, %v", ok, err)
+					}
+				}()
+			}
+
+			wg.Wait()
+
+			all, err := store.All()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(all) != 0 {
+				t.Errorf("got %d sessions, want none", len(all))
+			}
+		})
+	}
+}
+
+func TestStoreUpdateMissing(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := store.Update(uuid.New(), func(s *Session) error { return nil })
+			if err != ErrNotFound {
+				t.Errorf("Update() = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/m4tthewde/truffle/internal/twitch"
+)
+
+// validateToken checks tokens with Twitch, tests replace it.
+var validateToken = twitch.ValidateToken
+
+// ValidateTicker validates the tokens of all sessions once an hour, as
+// required by Twitch, and terminates sessions whose token was revoked.
+func ValidateTicker() {
+	ticker := time.NewTicker(1 * time.Hour)
+	for {
+		<-ticker.C
+		all, err := store.All()
+		if err != nil {
+			slog.Error("Listing sessions failed", "err", err)
+			continue
+		}
+
+		for _, s := range all {
+			validate(&s)
+		}
+	}
+}
+
+func validate(s *Session) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := validateToken(ctx, s.AccessToken)
+	if err == nil {
+		return
+	}
+
+	if !errors.Is(err, twitch.ErrUnauthorized) {
+		slog.Warn("Validating token failed", "user_id", s.UserID, "err", err)
+		return
+	}
+
+	// an expired token is also rejected, that alone is no reason to log out
+	if s.NeedsRefresh() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err = Refresh(ctx, s)
+		if err != nil {
+			slog.Warn("Refreshing token failed", "user_id", s.UserID, "err", err)
+		}
+
+		return
+	}
+
+	// s is from the start of the sweep, since then a login may have replaced
+	// the token and revoked the old one
+	current, ok, err := store.Get(s.ID)
+	if err != nil {
+		slog.Warn("Reading session failed", "user_id", s.UserID, "err", err)
+		return
+	}
+
+	if !ok || current.AccessToken != s.AccessToken {
+		return
+	}
+
+	Terminate(&current, ErrTokenRevoked)
+}
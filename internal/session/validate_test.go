@@ -0,0 +1,60 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m4tthewde/truffle/internal/twitch"
+)
+
+func TestValidateReplacedToken(t *testing.T) {
+	store = newMemoryStore()
+	ended = make(map[uuid.UUID]endedSession)
+
+	validateToken = func(ctx context.Context, token string) (*twitch.ValidationResponse, error) {
+		return nil, twitch.ErrUnauthorized
+	}
+	t.Cleanup(func() { validateToken = twitch.ValidateToken })
+
+	s := testSession()
+	s.Expiry = time.Now().Add(time.Hour)
+	err := AddSession(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a login replaced the token after the sweep listed the sessions
+	stale := s
+	s.AccessToken = "new access"
+	_, err = store.Update(s.ID, func(current *Session) error {
+		current.AccessToken = s.AccessToken
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate(&stale)
+
+	_, ok, err := store.Get(s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("session with a replaced token was terminated")
+	}
+
+	validate(&s)
+
+	_, ok, err = store.Get(s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok {
+		t.Error("session with a revoked token wasn't terminated")
+	}
+}
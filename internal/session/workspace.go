@@ -0,0 +1,53 @@
+package session
+
+import "slices"
+
+const (
+	// LayoutTabs shows one chat room at a time
+	LayoutTabs = "tabs"
+	// LayoutSplit shows all chat rooms side by side
+	LayoutSplit = "split"
+)
+
+// Workspace is the set of chat rooms a user keeps open, it's shared by all
+// their sessions.
+type Workspace struct {
+	Channels []string `json:"channels"`
+	Layout   string   `json:"layout"`
+}
+
+// Has reports whether the room of channel is open.
+func (w *Workspace) Has(channel string) bool {
+	return slices.Contains(w.Channels, channel)
+}
+
+// Add opens the room of channel, unless it's open already.
+func (w *Workspace) Add(channel string) {
+	if !w.Has(channel) {
+		w.Channels = append(w.Channels, channel)
+	}
+}
+
+// Remove closes the room of channel.
+func (w *Workspace) Remove(channel string) {
+	w.Channels = slices.DeleteFunc(w.Channels, func(c string) bool { return c == channel })
+}
+
+func (w Workspace) clone() Workspace {
+	w.Channels = slices.Clone(w.Channels)
+	if w.Layout == "" {
+		w.Layout = LayoutTabs
+	}
+
+	return w
+}
+
+// GetWorkspace returns the rooms userID has open.
+func GetWorkspace(userID string) (Workspace, error) {
+	return store.GetWorkspace(userID)
+}
+
+// UpdateWorkspace atomically applies fn to the rooms userID has open.
+func UpdateWorkspace(userID string, fn func(w *Workspace) error) (Workspace, error) {
+	return store.UpdateWorkspace(userID, fn)
+}
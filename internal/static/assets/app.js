@@ -0,0 +1,181 @@
+// Truffle's own front-end code, loaded after htmx.
+
+// errors come with a message for #error, show it instead of ignoring the response
+htmx.on("htmx:beforeSwap", function (evt) {
+	if (evt.detail.isError && evt.detail.xhr.getResponseHeader("HX-Retarget")) {
+		evt.detail.shouldSwap = true;
+		evt.detail.isError = false;
+	}
+});
+
+htmx.on("htmx:beforeRequest", function () {
+	const error = document.getElementById("error");
+	if (error) {
+		error.innerHTML = "";
+	}
+});
+
+// Chat rooms keep their state on their elements: data-auto-scroll is "false"
+// once the user scrolled up, data-scroll-top remembers the position of a
+// hidden tab.
+
+function roomContainer(room) {
+	return room.querySelector(".chat-room-div");
+}
+
+function autoScrolls(room) {
+	return room.dataset.autoScroll !== "false";
+}
+
+function scrollToBottom(room) {
+	const container = roomContainer(room);
+	container.scrollTop = container.scrollHeight;
+}
+
+function isHidden(room) {
+	return room.offsetParent === null;
+}
+
+function tabOf(room) {
+	return document.getElementById("tab-" + room.dataset.channel);
+}
+
+function increment(counter) {
+	counter.textContent = String(Number(counter.textContent) + 1);
+}
+
+// activateRoom shows the room of channel when rooms are shown as tabs, and
+// makes the address point at it.
+function activateRoom(channel) {
+	const rooms = document.getElementById("chat-rooms");
+	if (!rooms) {
+		return;
+	}
+
+	for (const room of rooms.querySelectorAll(".room")) {
+		const active = room.dataset.channel === channel;
+		if (!active && !isHidden(room)) {
+			room.dataset.scrollTop = String(roomContainer(room).scrollTop);
+		}
+
+		room.classList.toggle("active", active);
+
+		const tab = tabOf(room);
+		if (tab) {
+			tab.classList.toggle("active", active);
+		}
+
+		if (active) {
+			if (autoScrolls(room)) {
+				scrollToBottom(room);
+			} else if (room.dataset.scrollTop) {
+				roomContainer(room).scrollTop = Number(room.dataset.scrollTop);
+			}
+
+			if (tab) {
+				tab.querySelector(".unread").textContent = "";
+				tab.querySelector(".mentions").textContent = "";
+			}
+		}
+	}
+
+	// htmx only restores history entries it marked
+	history.replaceState({htmx: true}, "", "/chat/" + encodeURIComponent(channel));
+}
+
+// the server triggers this after a room was opened with the form
+document.addEventListener("openRoom", function (event) {
+	activateRoom(event.detail.value);
+});
+
+// a closed room may have been the shown one
+htmx.on("htmx:afterSettle", function () {
+	const rooms = document.getElementById("chat-rooms");
+	if (!rooms || rooms.querySelector(".room.active")) {
+		return;
+	}
+
+	const first = rooms.querySelector(".room");
+	if (first) {
+		activateRoom(first.dataset.channel);
+	} else if (location.pathname.startsWith("/chat/")) {
+		history.replaceState({htmx: true}, "", "/");
+	}
+});
+
+// scrolling through a chat room stops it from following new messages
+document.addEventListener("wheel", function (event) {
+	const room = event.target.closest(".room");
+	if (room && event.target.closest(".chat-room-div")) {
+		room.dataset.autoScroll = "false";
+	}
+});
+
+document.addEventListener("click", function (event) {
+	const resume = event.target.closest(".resume-autoscroll");
+	if (resume) {
+		const room = resume.closest(".room");
+		scrollToBottom(room);
+		room.dataset.autoScroll = "true";
+		return;
+	}
+
+	const select = event.target.closest(".tab-select");
+	if (select) {
+		activateRoom(select.closest(".tab").dataset.channel);
+		return;
+	}
+
+	// the button also stores the layout
+	const layout = event.target.closest("[data-layout]");
+	if (layout) {
+		const rooms = document.getElementById("chat-rooms");
+		rooms.classList.remove("tabs", "split");
+		rooms.classList.add(layout.dataset.layout);
+
+		for (const room of rooms.querySelectorAll(".room")) {
+			if (autoScrolls(room)) {
+				scrollToBottom(room);
+			}
+		}
+	}
+});
+
+htmx.on("htmx:oobAfterSwap", function (evt) {
+	const messages = evt.detail.target;
+	if (!messages.id.startsWith("messages-")) {
+		return;
+	}
+
+	const room = messages.closest(".room");
+	if (!room) {
+		return;
+	}
+
+	if (isHidden(room)) {
+		const tab = tabOf(room);
+		const message = messages.lastElementChild;
+		if (tab && message) {
+			increment(tab.querySelector(".unread"));
+			if (message.classList.contains("mention")) {
+				increment(tab.querySelector(".mentions"));
+			}
+		}
+	} else if (autoScrolls(room)) {
+		scrollToBottom(room);
+	}
+
+	const children = messages.children;
+	const limit = 500;
+	const excess = children.length - limit;
+
+	for (let i = 0; i < excess; i++) {
+		messages.removeChild(children[0])
+	}
+});
+
+htmx.on("htmx:afterRequest", function (evt) {
+	if (evt.detail.target.id === "logout-btn") {
+		window.location.href = "/"
+	}
+});
@@ -0,0 +1,83 @@
+//go:build ignore
+
+// fetch downloads the vendored front-end libraries into assets/vendor, run it
+// with go generate after changing a version. The files are checked in, so
+// building Truffle doesn't need network access.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const htmxVersion = "1.9.10"
+
+// vendorFile is a library and the sha256 it must have, so a compromised CDN
+// can't slip anything into the binary. Update the sum together with
+// htmxVersion, after checking the new file.
+type vendorFile struct {
+	url    string
+	sha256 string
+}
+
+// The sums are empty until someone who can reach unpkg.com checks and pins
+// them, fetch refuses to write any file without one.
+var files = map[string]vendorFile{
+	"assets/vendor/htmx.min.js": {
+		url:    "https://unpkg.com/htmx.org@" + htmxVersion + "/dist/htmx.min.js",
+		sha256: "",
+	},
+	"assets/vendor/ws.js": {
+		url:    "https://unpkg.com/htmx.org@" + htmxVersion + "/dist/ext/ws.js",
+		sha256: "",
+	},
+}
+
+func main() {
+	for path, file := range files {
+		err := fetch(path, file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+func fetch(path string, file vendorFile) error {
+	if file.sha256 == "" {
+		return fmt.Errorf("%s: no sha256 pinned", path)
+	}
+
+	resp, err := http.Get(file.url)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s: %s", file.url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != file.sha256 {
+		return fmt.Errorf("%s: sha256 is %x, expected %s", file.url, sum, file.sha256)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,91 @@
+// Package static serves the front-end assets embedded into the binary. Their
+// URLs contain a hash of their content, so browsers can cache them forever.
+package static
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//go:generate go run fetch.go
+
+//go:embed assets
+var assets embed.FS
+
+// vendored are the third-party assets downloaded by fetch.go.
+var vendored = []string{"vendor/htmx.min.js", "vendor/ws.js"}
+
+var (
+	// paths maps asset names to the URL paths they are served at
+	paths = make(map[string]string)
+	// files maps the hashed names back to the embedded files
+	files = make(map[string]string)
+)
+
+// Init hashes the embedded assets and makes sure the vendored ones are
+// there.
+func Init() error {
+	err := fs.WalkDir(assets, "assets", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := assets.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		name := strings.TrimPrefix(p, "assets/")
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+
+		paths[name] = "/static/" + hashed
+		files[hashed] = p
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range vendored {
+		if _, ok := paths[name]; !ok {
+			return fmt.Errorf("static asset %s is missing, run go generate ./internal/static", name)
+		}
+	}
+
+	return nil
+}
+
+// Path returns the URL path of an asset, name is relative to the assets
+// directory.
+func Path(name string) string {
+	p, ok := paths[name]
+	if !ok {
+		panic("unknown static asset " + name)
+	}
+
+	return p
+}
+
+// Handler serves the assets under /static/. As the names change with the
+// content, responses can be cached indefinitely.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := files[strings.TrimPrefix(r.URL.Path, "/static/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		http.ServeFileFS(w, r, assets, p)
+	})
+}
@@ -0,0 +1,63 @@
+package twitch
+
+import (
+	"sync"
+	"time"
+)
+
+// ReaderHealth summarizes how the EventSub readers are doing.
+type ReaderHealth struct {
+	Connections int
+	LastError   string
+	LastErrorAt time.Time
+	// LastSubscribedAt is when a reader last finished subscribing
+	LastSubscribedAt time.Time
+}
+
+// Healthy reports whether the readers recovered from the last error, i.e.
+// a reader subscribed successfully since.
+func (h ReaderHealth) Healthy() bool {
+	return h.LastErrorAt.IsZero() || h.LastSubscribedAt.After(h.LastErrorAt)
+}
+
+var (
+	healthMu sync.Mutex
+	health   ReaderHealth
+)
+
+// Readers returns the current health of the EventSub readers.
+func Readers() ReaderHealth {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	return health
+}
+
+func readerConnected() {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	health.Connections++
+}
+
+func readerDisconnected() {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	health.Connections--
+}
+
+func readerSubscribed() {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	health.LastSubscribedAt = time.Now()
+}
+
+func readerFailed(err error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	health.LastError = err.Error()
+	health.LastErrorAt = time.Now()
+}
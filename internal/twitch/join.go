@@ -0,0 +1,137 @@
+package twitch
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Step is a stage of joining a channel.
+type Step string
+
+const (
+	StepResolving   Step = "resolve_channel"
+	StepConnecting  Step = "connect"
+	StepWelcome     Step = "welcome"
+	StepSubscribing Step = "subscribe"
+	StepJoined      Step = "joined"
+)
+
+// Progress reports how joining a channel goes.
+type Progress struct {
+	Step Step
+	// Err is why the reader stopped during Step
+	Err error
+	// Moderation is whether bans and timeouts are received, it's set once
+	// the channel was joined
+	Moderation bool
+}
+
+// channelIDTTL is how long a resolved channel ID is reused. IDs never change,
+// but after a rename the login can end up with another account.
+const channelIDTTL = 1 * time.Hour
+
+type cachedChannelID struct {
+	id       string
+	resolved time.Time
+}
+
+var (
+	channelIDsMu sync.Mutex
+	channelIDs   = make(map[string]cachedChannelID)
+)
+
+// ResolveChannel returns the ID of the channel with the given login. IDs
+// are cached, so rejoining a channel doesn't wait for Helix.
+func ResolveChannel(ctx context.Context, tokens TokenSource, login string) (string, error) {
+	start := time.Now()
+	defer func() {
+		joinStepDuration.WithLabelValues(string(StepResolving)).Observe(time.Since(start).Seconds())
+	}()
+
+	channelIDsMu.Lock()
+	cached, ok := channelIDs[login]
+	channelIDsMu.Unlock()
+
+	if ok && time.Since(cached.resolved) < channelIDTTL {
+		channelIDCacheTotal.WithLabelValues("hit").Inc()
+		return cached.id, nil
+	}
+
+	channelIDCacheTotal.WithLabelValues("miss").Inc()
+
+	var channelID string
+	err := WithRetry(ctx, tokens, func(accessToken string) error {
+		var err error
+		channelID, err = GetChannelID(ctx, accessToken, login)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	channelIDsMu.Lock()
+	defer channelIDsMu.Unlock()
+
+	// drop what expired, so logins that are only joined once don't pile up
+	for l, c := range channelIDs {
+		if time.Since(c.resolved) >= channelIDTTL {
+			delete(channelIDs, l)
+		}
+	}
+
+	channelIDs[login] = cachedChannelID{id: channelID, resolved: time.Now()}
+
+	return channelID, nil
+}
+
+// joinTimer reports the steps of joining a channel as they start and
+// records how long each one took.
+type joinTimer struct {
+	progress chan<- Progress
+	step     Step
+	start    time.Time
+	took     []any
+}
+
+func (t *joinTimer) next(ctx context.Context, step Step) {
+	t.nextProgress(ctx, Progress{Step: step})
+}
+
+// joined is like next, but also tells whether moderation events are
+// received.
+func (t *joinTimer) joined(ctx context.Context, moderation bool) {
+	t.nextProgress(ctx, Progress{Step: StepJoined, Moderation: moderation})
+}
+
+func (t *joinTimer) nextProgress(ctx context.Context, p Progress) {
+	now := time.Now()
+	if t.step != "" {
+		took := now.Sub(t.start)
+		joinStepDuration.WithLabelValues(string(t.step)).Observe(took.Seconds())
+		t.took = append(t.took, slog.Duration(string(t.step), took))
+	}
+
+	t.step = p.Step
+	t.start = now
+
+	if p.Step == StepJoined {
+		slog.InfoContext(ctx, "Joined channel", t.took...)
+	}
+
+	t.send(ctx, p)
+}
+
+// fail reports why the reader stopped.
+func (t *joinTimer) fail(ctx context.Context, err error) {
+	readerFailed(err)
+	t.send(ctx, Progress{Step: t.step, Err: err})
+}
+
+func (t *joinTimer) send(ctx context.Context, p Progress) {
+	select {
+	case t.progress <- p:
+	case <-ctx.Done():
+	}
+}
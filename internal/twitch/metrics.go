@@ -0,0 +1,78 @@
+package twitch
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	eventSubConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "truffle_eventsub_connections",
+		Help: "Open EventSub websocket connections.",
+	})
+
+	subscriptionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "truffle_eventsub_subscriptions_total",
+		Help: "EventSub subscriptions by type and whether they were created or failed.",
+	}, []string{"type", "result"})
+
+	notificationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "truffle_eventsub_notifications_total",
+		Help: "EventSub notifications received by subscription type.",
+	}, []string{"type"})
+
+	reconnectsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "truffle_eventsub_reconnects_total",
+		Help: "Reconnect requests sent by EventSub.",
+	})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "truffle_twitch_request_duration_seconds",
+		Help: "Latency of requests to the Twitch API by endpoint and status.",
+	}, []string{"endpoint", "status"})
+
+	joinStepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "truffle_room_join_step_duration_seconds",
+		Help: "Time spent in each step of joining a channel.",
+	}, []string{"step"})
+
+	channelIDCacheTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "truffle_channel_id_cache_total",
+		Help: "Channel ID lookups by whether they were answered from the cache.",
+	}, []string{"result"})
+
+	rateLimitRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "truffle_helix_ratelimit_remaining",
+		Help: "Points left in the Helix rate limit bucket of the most recent request.",
+	})
+)
+
+// do sends req and records its latency and the remaining rate limit.
+func do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+
+	endpoint := req.Method + " " + req.URL.Path
+	requestDuration.WithLabelValues(endpoint, status).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		return nil, err
+	}
+
+	// only Helix sends it, not the OAuth endpoints
+	remaining, err := strconv.Atoi(resp.Header.Get("Ratelimit-Remaining"))
+	if err == nil {
+		rateLimitRemaining.Set(float64(remaining))
+	}
+
+	return resp, nil
+}
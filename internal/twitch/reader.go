@@ -4,11 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/m4tthewde/truffle/internal/logging"
 )
 
 type Message struct {
@@ -54,42 +56,126 @@ type ChatMessage struct {
 	Text string `json:"text"`
 }
 
-func Read(accessToken string, cond Condition, wsChan chan Payload, ctx context.Context) {
+// tokenCheckInterval is how often the reader makes sure its subscriptions
+// are authorized by the current access token.
+const tokenCheckInterval = 1 * time.Minute
+
+type reader struct {
+	tokens        TokenSource
+	cond          Condition
+	wsChan        chan Payload
+	sessionID     string
+	accessToken   string
+	subscriptions map[string]string
+	lastCheck     time.Time
+}
+
+// Read joins a channel and sends its notifications to wsChan until ctx is
+// done. The steps of joining are sent to progress as they start, followed
+// by the error that stopped the reader, if any.
+func Read(tokens TokenSource, cond Condition, wsChan chan Payload, progress chan<- Progress, ctx context.Context) {
 	defer close(wsChan)
 
-	log.Printf("Joining %s as user %s\n", cond.BroadcasterUserID, cond.UserID)
+	slog.InfoContext(ctx, "Joining channel")
+	timer := joinTimer{progress: progress}
+	timer.next(ctx, StepConnecting)
+
 	u := url.URL{Scheme: "wss", Host: "eventsub.wss.twitch.tv", Path: "/ws"}
 
 	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "Connecting to EventSub failed", "err", err)
+		if ctx.Err() == nil {
+			timer.fail(ctx, err)
+		}
+
 		return
 	}
 
 	defer c.Close()
 
+	eventSubConnections.Inc()
+	defer eventSubConnections.Dec()
+	readerConnected()
+	defer readerDisconnected()
+
+	timer.next(ctx, StepWelcome)
+
+	// unblocks ReadMessage as soon as the room is closed
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+
+	r := reader{
+		tokens:        tokens,
+		cond:          cond,
+		wsChan:        wsChan,
+		subscriptions: make(map[string]string),
+	}
+
+	defer r.unsubscribe(ctx)
+
 	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("Parted %s\n", cond.BroadcasterUserID)
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				slog.InfoContext(ctx, "Parted channel")
+			} else {
+				slog.ErrorContext(ctx, "Reading from EventSub failed", "err", err)
+				timer.fail(ctx, err)
+			}
+
 			return
-		default:
-			_, data, err := c.ReadMessage()
+		}
+
+		sessionID := r.sessionID
+		err = r.handleMsg(ctx, data)
+		if err != nil {
+			slog.ErrorContext(ctx, "Handling EventSub message failed", "err", err)
+			if ctx.Err() == nil {
+				timer.fail(ctx, err)
+			}
+
+			return
+		}
+
+		if r.sessionID != sessionID {
+			// welcomed, everything from now on concerns this EventSub session
+			ctx = logging.With(ctx, slog.String("eventsub_session_id", r.sessionID))
+			slog.DebugContext(ctx, "Connected to EventSub")
+			timer.next(ctx, StepSubscribing)
+
+			err = r.subscribe(ctx)
 			if err != nil {
-				log.Println(err)
+				slog.ErrorContext(ctx, "Subscribing failed", "err", err)
+				// closing the room while subscribing isn't a failure
+				if ctx.Err() == nil {
+					timer.fail(ctx, err)
+				}
+
 				return
 			}
 
-			err = handleMsg(data, accessToken, cond, wsChan)
+			readerSubscribed()
+			timer.joined(ctx, r.moderation())
+		}
+
+		if r.sessionID != "" && time.Since(r.lastCheck) >= tokenCheckInterval {
+			err = r.checkToken(ctx)
 			if err != nil {
-				log.Println(err)
+				slog.ErrorContext(ctx, "Re-authorizing subscriptions failed", "err", err)
+				if ctx.Err() == nil {
+					timer.fail(ctx, err)
+				}
+
 				return
 			}
 		}
 	}
 }
 
-func handleMsg(data []byte, accessToken string, cond Condition, wsChan chan Payload) error {
+func (r *reader) handleMsg(ctx context.Context, data []byte) error {
 	var msg Message
 	err := json.Unmarshal(data, &msg)
 	if err != nil {
@@ -97,43 +183,163 @@ func handleMsg(data []byte, accessToken string, cond Condition, wsChan chan Payl
 	}
 
 	if msg.Metadata.MessageType == "session_welcome" {
-		_, err := createEventSub(accessToken, msg.Payload.Session.ID, cond, MessageType)
-		if err != nil {
-			return err
+		r.sessionID = msg.Payload.Session.ID
+	}
+
+	if msg.Metadata.MessageType == "session_reconnect" {
+		// TODO: implement reconnect logic
+		slog.WarnContext(ctx, "EventSub asked to reconnect")
+		reconnectsTotal.Inc()
+	}
+
+	if msg.Metadata.MessageType == "revocation" {
+		// TODO: what do we do in this case?
+		slog.WarnContext(ctx, "Subscription revoked", "type", msg.Payload.Subscription.Type)
+	}
+
+	if msg.Metadata.MessageType == "notification" {
+		notificationsTotal.WithLabelValues(msg.Payload.Subscription.Type).Inc()
+
+		select {
+		case r.wsChan <- msg.Payload:
+		case <-ctx.Done():
 		}
+	}
 
-		_, err = createEventSub(accessToken, msg.Payload.Session.ID, cond, BanType)
-		if err != nil {
-			if errors.Is(err, ErrForbidden) {
-				log.Printf("User %s is not mod in channel %s\n", cond.UserID, cond.BroadcasterUserID)
-			} else {
+	return nil
+}
+
+// subscriptionResult is the outcome of creating one subscription.
+type subscriptionResult struct {
+	id          string
+	accessToken string
+	err         error
+}
+
+// subscribeTimeout bounds creating the subscriptions. Twitch closes an
+// EventSub connection without any after 10 seconds anyway.
+const subscribeTimeout = 10 * time.Second
+
+// subscribe creates the subscriptions concurrently, Helix takes a while for
+// each one.
+func (r *reader) subscribe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, subscribeTimeout)
+	defer cancel()
+
+	subTypes := []string{MessageType, BanType, UnbanType}
+	results := make([]subscriptionResult, len(subTypes))
+
+	var wg sync.WaitGroup
+	for i, subType := range subTypes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res := &results[i]
+			res.err = WithRetry(ctx, r.tokens, func(accessToken string) error {
+				var err error
+				res.id, err = createEventSub(ctx, accessToken, r.sessionID, r.cond, subType)
+				res.accessToken = accessToken
 				return err
+			})
+		}()
+	}
+
+	wg.Wait()
+
+	// record every created subscription first, so they're deleted even if
+	// another one failed
+	var err error
+	for i, subType := range subTypes {
+		res := results[i]
+		if res.err != nil {
+			subscriptionsTotal.WithLabelValues(subType, "failed").Inc()
+
+			if subType != MessageType && errors.Is(res.err, ErrForbidden) {
+				slog.InfoContext(ctx, "User is not a moderator, skipping subscription", "type", subType)
+				continue
 			}
-		}
 
-		_, err = createEventSub(accessToken, msg.Payload.Session.ID, cond, UnbanType)
-		if err != nil {
-			if errors.Is(err, ErrForbidden) {
-				log.Printf("User %s is not mod in channel %s\n", cond.UserID, cond.BroadcasterUserID)
-			} else {
-				return err
+			if errors.Is(res.err, ErrForbidden) {
+				res.err = ErrChatForbidden
+			}
+
+			if err == nil {
+				err = res.err
 			}
+
+			continue
 		}
+
+		subscriptionsTotal.WithLabelValues(subType, "created").Inc()
+		r.subscriptions[subType] = res.id
+		r.accessToken = res.accessToken
 	}
 
-	if msg.Metadata.MessageType == "session_reconnect" {
-		// TODO: implement reconnect logic
-		log.Println("session_reconnect")
+	r.lastCheck = time.Now()
+
+	return err
+}
+
+// moderation reports whether the reader receives bans and timeouts, only
+// moderators may subscribe to them.
+func (r *reader) moderation() bool {
+	_, ban := r.subscriptions[BanType]
+	_, unban := r.subscriptions[UnbanType]
+	return ban && unban
+}
+
+// checkToken recreates the subscriptions if the access token has been
+// refreshed since they were created, so they stay authorized.
+func (r *reader) checkToken(ctx context.Context) error {
+	r.lastCheck = time.Now()
+
+	accessToken, err := r.tokens.Token(ctx)
+	if err != nil {
+		return err
 	}
 
-	if msg.Metadata.MessageType == "revocation" {
-		// TODO: what do we do in this case?
-		log.Println("revocation")
+	if accessToken == r.accessToken {
+		return nil
 	}
 
-	if msg.Metadata.MessageType == "notification" {
-		wsChan <- msg.Payload
+	slog.InfoContext(ctx, "Re-authorizing subscriptions")
+	for subType, subscriptionID := range r.subscriptions {
+		err = deleteEventSub(ctx, accessToken, subscriptionID)
+		if err != nil {
+			return err
+		}
+
+		delete(r.subscriptions, subType)
 	}
 
-	return nil
+	return r.subscribe(ctx)
+}
+
+// unsubscribeTimeout bounds the cleanup after the reader stopped.
+const unsubscribeTimeout = 5 * time.Second
+
+// unsubscribe deletes the subscriptions the reader created. logCtx only
+// provides the log attributes, it's usually cancelled already.
+func (r *reader) unsubscribe(logCtx context.Context) {
+	if len(r.subscriptions) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(logCtx), unsubscribeTimeout)
+	defer cancel()
+
+	accessToken, err := r.tokens.Token(ctx)
+	if err != nil {
+		// without a session, its subscriptions are revoked anyway
+		slog.InfoContext(ctx, "Not deleting subscriptions", "err", err)
+		return
+	}
+
+	for subType, subscriptionID := range r.subscriptions {
+		err = deleteEventSub(ctx, accessToken, subscriptionID)
+		if err != nil {
+			slog.WarnContext(ctx, "Deleting subscription failed", "type", subType, "err", err)
+		}
+	}
 }
@@ -0,0 +1,9 @@
+package twitch
+
+const (
+	ScopeReadChat      = "user:read:chat"
+	ScopeWriteChat     = "user:write:chat"
+	ScopeModerate      = "channel:moderate"
+	ScopeManageAutoMod = "moderator:manage:automod"
+	ScopeReadFollowers = "moderator:read:followers"
+)
@@ -0,0 +1,35 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+)
+
+// TokenSource hands out user access tokens and refreshes them on demand.
+type TokenSource interface {
+	// Token returns an access token, refreshing it first if it is about to expire.
+	Token(ctx context.Context) (string, error)
+	// Refresh obtains a new access token, e.g. after Twitch rejected the current one.
+	Refresh(ctx context.Context) (string, error)
+}
+
+// WithRetry calls fn with a token from ts. If Twitch rejects the token,
+// it is refreshed and fn is called once more.
+func WithRetry(ctx context.Context, ts TokenSource, fn func(accessToken string) error) error {
+	accessToken, err := ts.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(accessToken)
+	if !errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+
+	accessToken, err = ts.Refresh(ctx)
+	if err != nil {
+		return err
+	}
+
+	return fn(accessToken)
+}
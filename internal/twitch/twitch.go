@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/m4tthewde/truffle/internal/config"
 )
@@ -39,9 +41,22 @@ const (
 	UnbanType   = "channel.unban"
 )
 
-var ErrForbidden = errors.New("403 Forbidden")
+var (
+	ErrForbidden           = errors.New("403 Forbidden")
+	ErrUnauthorized        = errors.New("401 Unauthorized")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrChannelNotFound     = errors.New("channel not found")
+	// ErrSubscriptionLimit means the user has as many EventSub
+	// subscriptions or connections as Twitch allows
+	ErrSubscriptionLimit = errors.New("subscription limit reached")
+	// ErrRateLimited means Helix rate limited the request, unlike
+	// ErrSubscriptionLimit it goes away on its own
+	ErrRateLimited = errors.New("rate limited")
+	// ErrChatForbidden means the user may not read the channel's chat
+	ErrChatForbidden = errors.New("reading chat is forbidden")
+)
 
-func createEventSub(accessToken string, sessionID string, condition Condition, subType string) (string, error) {
+func createEventSub(ctx context.Context, accessToken string, sessionID string, condition Condition, subType string) (string, error) {
 	transport := make(map[string]string)
 	transport["method"] = "websocket"
 	transport["session_id"] = sessionID
@@ -57,7 +72,7 @@ func createEventSub(accessToken string, sessionID string, condition Condition, s
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", "https://api.twitch.tv/helix/eventsub/subscriptions", bytes.NewBuffer(jsonStr))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.twitch.tv/helix/eventsub/subscriptions", bytes.NewBuffer(jsonStr))
 	if err != nil {
 		return "", err
 	}
@@ -67,15 +82,30 @@ func createEventSub(accessToken string, sessionID string, condition Condition, s
 	req.Header.Add("Client-Id", config.Conf.ClientID)
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := do(req)
 	if err != nil {
 		return "", err
 	}
 
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 401 {
+		return "", ErrUnauthorized
+	}
+
 	if resp.StatusCode == 403 {
 		return "", ErrForbidden
 	}
 
+	if resp.StatusCode == 429 {
+		// Helix answers both with 429, only a rate limit runs out of points
+		if resp.Header.Get("Ratelimit-Remaining") == "0" {
+			return "", ErrRateLimited
+		}
+
+		return "", ErrSubscriptionLimit
+	}
+
 	if resp.StatusCode != 202 {
 		return "", errors.New(resp.Status)
 	}
@@ -86,9 +116,56 @@ func createEventSub(accessToken string, sessionID string, condition Condition, s
 		return "", err
 	}
 
+	if len(eventsubResponse.Data) == 0 {
+		return "", errors.New("no subscription in the response")
+	}
+
 	return eventsubResponse.Data[0].ID, nil
 }
 
+func deleteEventSub(ctx context.Context, accessToken string, subscriptionID string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", "https://api.twitch.tv/helix/eventsub/subscriptions", nil)
+	if err != nil {
+		return err
+	}
+
+	q := req.URL.Query()
+	q.Add("id", subscriptionID)
+	req.URL.RawQuery = q.Encode()
+
+	req.Header.Add("Client-Id", config.Conf.ClientID)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	resp, err := do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 401 {
+		return ErrUnauthorized
+	}
+
+	if resp.StatusCode != 204 && resp.StatusCode != 404 {
+		return errors.New(resp.Status)
+	}
+
+	return nil
+}
+
+// loginPattern matches Twitch logins: up to 25 lowercase letters, digits and
+// underscores, not starting with an underscore. New accounts need at least
+// four characters, but older ones can be shorter.
+var loginPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_]{0,24}$`)
+
+// NormalizeLogin turns a channel name as users type it, like "#Channel", into
+// a login. ok is false if it can't be one, so Helix needn't be asked.
+func NormalizeLogin(channel string) (login string, ok bool) {
+	login = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(channel), "#"))
+	return login, loginPattern.MatchString(login)
+}
+
 type ChannelResponse struct {
 	Data []ChannelData `json:"data"`
 }
@@ -112,11 +189,17 @@ func GetChannelID(ctx context.Context, accessToken string, channel string) (stri
 	req.Header.Add("Client-Id", config.Conf.ClientID)
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := do(req)
 	if err != nil {
 		return "", err
 	}
 
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 401 {
+		return "", ErrUnauthorized
+	}
+
 	if resp.StatusCode != 200 {
 		return "", errors.New(resp.Status)
 	}
@@ -127,11 +210,23 @@ func GetChannelID(ctx context.Context, accessToken string, channel string) (stri
 		return "", err
 	}
 
+	if len(channelResponse.Data) == 0 {
+		return "", ErrChannelNotFound
+	}
+
 	return channelResponse.Data[0].ID, nil
 }
 
 type TokenResponse struct {
-	AccessToken string `json:"access_token"`
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in"`
+	Scope        []string `json:"scope"`
+}
+
+// Expiry returns the point in time at which the access token stops being valid.
+func (t *TokenResponse) Expiry() time.Time {
+	return time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
 }
 
 func GetToken(code string, clientID string, clientSecret string, uri string) (*TokenResponse, error) {
@@ -155,11 +250,13 @@ func GetToken(code string, clientID string, clientSecret string, uri string) (*T
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := do(req)
 	if err != nil {
 		return nil, err
 	}
 
+	defer resp.Body.Close()
+
 	if resp.StatusCode != 200 {
 		return nil, errors.New(resp.Status)
 	}
@@ -173,13 +270,90 @@ func GetToken(code string, clientID string, clientSecret string, uri string) (*T
 	return &loginResponse, nil
 }
 
+func RefreshToken(ctx context.Context, refreshToken string, clientID string, clientSecret string) (*TokenResponse, error) {
+	data := url.Values{}
+	data.Set("client_id", clientID)
+	data.Set("client_secret", clientSecret)
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refreshToken)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://id.twitch.tv/oauth2/token", strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	// Twitch answers with 400 if the refresh token was revoked or is otherwise unusable
+	if resp.StatusCode == 400 || resp.StatusCode == 401 {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
+	}
+
+	var tokenResponse TokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&tokenResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse, nil
+}
+
+// GetAppToken obtains an app access token with the client credentials grant.
+func GetAppToken(ctx context.Context, clientID string, clientSecret string) (*TokenResponse, error) {
+	data := url.Values{}
+	data.Set("client_id", clientID)
+	data.Set("client_secret", clientSecret)
+	data.Set("grant_type", "client_credentials")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://id.twitch.tv/oauth2/token", strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
+	}
+
+	var tokenResponse TokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&tokenResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse, nil
+}
+
 type ValidationResponse struct {
-	UserID string `json:"user_id"`
-	Login  string `json:"login"`
+	UserID    string   `json:"user_id"`
+	Login     string   `json:"login"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expires_in"`
 }
 
-func ValidateToken(accessToken string) (*ValidationResponse, error) {
-	req, err := http.NewRequest("GET", "https://id.twitch.tv/oauth2/validate", nil)
+func ValidateToken(ctx context.Context, accessToken string) (*ValidationResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://id.twitch.tv/oauth2/validate", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -188,11 +362,17 @@ func ValidateToken(accessToken string) (*ValidationResponse, error) {
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := do(req)
 	if err != nil {
 		return nil, err
 	}
 
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 401 {
+		return nil, ErrUnauthorized
+	}
+
 	if resp.StatusCode != 200 {
 		return nil, errors.New(resp.Status)
 	}
@@ -218,11 +398,13 @@ func RevokeToken(ctx context.Context, accessToken string) error {
 
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := do(req)
 	if err != nil {
 		return err
 	}
 
+	defer resp.Body.Close()
+
 	if resp.StatusCode != 200 {
 		return errors.New(resp.Status)
 	}